@@ -0,0 +1,76 @@
+package search
+
+import (
+	"context"
+
+	"git.mypierian.com/borghives/kosmos-go"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func NewDocument(page sitepages.Page, stanzas []sitepages.Stanza) Document {
+	byID := make(map[bson.ObjectID]sitepages.Stanza, len(stanzas))
+	for _, stanza := range stanzas {
+		byID[stanza.ID] = stanza
+	}
+
+	doc := Document{
+		Root:     page.Root,
+		PageID:   page.ID,
+		Title:    page.Title,
+		Abstract: page.Abstract,
+		Tags:     page.Infos.Tags,
+	}
+
+	// keep the reading order of the page contents
+	for _, content := range page.Contents {
+		stanza, ok := byID[content]
+		if !ok {
+			continue
+		}
+		doc.Stanzas = append(doc.Stanzas, Passage{ID: stanza.ID, Content: stanza.Content})
+	}
+	return doc
+}
+
+// LoadDocument builds the Document of the latest version of root. It returns
+// nil when the root has no page.
+func LoadDocument(ctx context.Context, root bson.ObjectID) (*Document, error) {
+	pages, err := kosmos.Detect[sitepages.Page](
+		kosmos.Fld("Root").ID().Eq(root),
+	).Sort(kosmos.Fld("EventAt").Desc(), kosmos.Fld("ID").Desc()).Limit(1).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pages) == 0 {
+		return nil, nil
+	}
+
+	page := pages[0]
+	var stanzas []sitepages.Stanza
+	if len(page.Contents) > 0 {
+		stanzas, err = kosmos.Detect[sitepages.Stanza](
+			kosmos.Fld("ID").In(page.Contents),
+		).Limit(int64(len(page.Contents))).PullAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	doc := NewDocument(page, stanzas)
+	return &doc, nil
+}
+
+// IndexRoot refreshes the index entry of root from its latest version.
+func IndexRoot(ctx context.Context, index Index, root bson.ObjectID) error {
+	doc, err := LoadDocument(ctx, root)
+	if err != nil {
+		return err
+	}
+
+	if doc == nil {
+		return index.Remove(ctx, root)
+	}
+	return index.Put(ctx, *doc)
+}
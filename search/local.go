@@ -0,0 +1,93 @@
+package search
+
+import (
+	"context"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// LocalIndex is an in-memory inverted index. It is meant for tests and small
+// deployments; contents are lost on restart.
+type LocalIndex struct {
+	mutex    sync.RWMutex
+	docs     map[bson.ObjectID]candidate
+	postings map[string]map[bson.ObjectID]struct{}
+}
+
+func NewLocalIndex() *LocalIndex {
+	return &LocalIndex{
+		docs:     make(map[bson.ObjectID]candidate),
+		postings: make(map[string]map[bson.ObjectID]struct{}),
+	}
+}
+
+func (l *LocalIndex) Put(ctx context.Context, doc Document) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.remove(doc.Root)
+
+	weights := termWeights(doc)
+	l.docs[doc.Root] = candidate{Doc: doc, Weights: weights}
+	for term := range weights {
+		if l.postings[term] == nil {
+			l.postings[term] = make(map[bson.ObjectID]struct{})
+		}
+		l.postings[term][doc.Root] = struct{}{}
+	}
+	return nil
+}
+
+func (l *LocalIndex) Remove(ctx context.Context, root bson.ObjectID) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	l.remove(root)
+	return nil
+}
+
+func (l *LocalIndex) remove(root bson.ObjectID) {
+	existing, ok := l.docs[root]
+	if !ok {
+		return
+	}
+
+	for term := range existing.Weights {
+		delete(l.postings[term], root)
+		if len(l.postings[term]) == 0 {
+			delete(l.postings, term)
+		}
+	}
+	delete(l.docs, root)
+}
+
+func (l *LocalIndex) Search(ctx context.Context, query Query) ([]Result, error) {
+	terms := queryTerms(query.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	docFreq := make(map[string]int, len(terms))
+	var candidates []candidate
+	for i, term := range terms {
+		posting := l.postings[term]
+		docFreq[term] = len(posting)
+
+		// every term must match, so candidates come from the first posting list
+		if i == 0 {
+			for root := range posting {
+				candidates = append(candidates, l.docs[root])
+			}
+		}
+	}
+
+	results := rank(candidates, terms, docFreq, len(l.docs), query.Limit)
+	for i := range results {
+		results[i].Snippets = snippets(l.docs[results[i].Root].Doc, terms)
+	}
+	return results, nil
+}
@@ -0,0 +1,107 @@
+package search
+
+import (
+	"context"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("The Quick, brown-fox à été 42!")
+	expected := []string{"quick", "brown", "fox", "été", "42"}
+
+	if len(tokens) != len(expected) {
+		t.Fatalf("expected %d tokens, got %v", len(expected), tokens)
+	}
+	for i, tok := range tokens {
+		if tok.Term != expected[i] {
+			t.Errorf("token %d: expected %q, got %q", i, expected[i], tok.Term)
+		}
+	}
+
+	if tokens[0].Start != 4 || tokens[0].End != 9 {
+		t.Errorf("expected byte offsets of quick to be [4,9), got [%d,%d)", tokens[0].Start, tokens[0].End)
+	}
+}
+
+func TestLocalIndexSearch(t *testing.T) {
+	ctx := context.Background()
+	index := NewLocalIndex()
+
+	titled := Document{Root: bson.NewObjectID(), Title: "Kosmos storage engine", Tags: []string{"database"}}
+	stanza := Passage{ID: bson.NewObjectID(), Content: "An introduction to how the storage layer of kosmos keeps every version around."}
+	mentioned := Document{Root: bson.NewObjectID(), Title: "Release notes", Stanzas: []Passage{stanza}}
+	unrelated := Document{Root: bson.NewObjectID(), Title: "Gardening tips", Abstract: "storage sheds"}
+
+	for _, doc := range []Document{titled, mentioned, unrelated} {
+		if err := index.Put(ctx, doc); err != nil {
+			t.Fatalf("Put failed: %v", err)
+		}
+	}
+
+	results, err := index.Search(ctx, Query{Text: "Kosmos storage"})
+	if err != nil {
+		t.Fatalf("Search failed: %v", err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("expected 2 results matching every term, got %d", len(results))
+	}
+
+	if results[0].Root != titled.Root {
+		t.Errorf("expected title match to rank first, got %s", results[0].Title)
+	}
+
+	if len(results[1].Snippets) != 1 {
+		t.Fatalf("expected one snippet for stanza match, got %v", results[1].Snippets)
+	}
+
+	snippet := results[1].Snippets[0]
+	if snippet.Stanza != stanza.ID || len(snippet.Highlights) != 2 {
+		t.Errorf("expected two highlights in stanza snippet, got %v", snippet)
+	}
+	for _, span := range snippet.Highlights {
+		word := snippet.Text[span.Start:span.End]
+		if word != "storage" && word != "kosmos" {
+			t.Errorf("unexpected highlighted text %q", word)
+		}
+	}
+
+	// re-indexing a root replaces its previous document
+	titled.Title = "Renamed"
+	titled.Tags = nil
+	index.Put(ctx, titled)
+	results, _ = index.Search(ctx, Query{Text: "kosmos"})
+	if len(results) != 1 || results[0].Root != mentioned.Root {
+		t.Errorf("expected stale terms to be dropped on Put, got %v", results)
+	}
+
+	index.Remove(ctx, mentioned.Root)
+	results, _ = index.Search(ctx, Query{Text: "kosmos"})
+	if len(results) != 0 {
+		t.Errorf("expected no results after Remove, got %v", results)
+	}
+}
+
+func TestSnippetWindow(t *testing.T) {
+	long := "lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor incididunt ut labore et dolore magna aliqua ut enim ad minim veniam quis nostrud exercitation ullamco laboris nisi ut aliquip ex ea commodo consequat target word here and then much more trailing text that keeps going for a while longer than the radius"
+	doc := Document{Stanzas: []Passage{{Content: long}}}
+
+	result := snippets(doc, []string{"target"})
+	if len(result) != 1 {
+		t.Fatalf("expected one snippet, got %d", len(result))
+	}
+
+	text := result[0].Text
+	if len(text) >= len(long) {
+		t.Errorf("expected snippet to be shorter than content")
+	}
+	if text[0] == ' ' || text[len(text)-1] == ' ' {
+		t.Errorf("expected snippet to be cut on word boundaries, got %q", text)
+	}
+	span := result[0].Highlights[0]
+	if text[span.Start:span.End] != "target" {
+		t.Errorf("expected highlight on target, got %q", text[span.Start:span.End])
+	}
+}
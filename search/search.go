@@ -0,0 +1,277 @@
+package search
+
+import (
+	"cmp"
+	"context"
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var TITLE_WEIGHT = 3.0
+var TAG_WEIGHT = 2.0
+var ABSTRACT_WEIGHT = 1.5
+var STANZA_WEIGHT = 1.0
+
+var MAX_QUERY_TERMS = 16
+var MAX_SNIPPETS = 3
+var SNIPPET_RADIUS = 80
+
+// saturation constant of the term frequency (as in BM25 k1)
+var TERM_SATURATION = 1.2
+
+type Passage struct {
+	ID      bson.ObjectID `json:"id" bson:"id"`
+	Content string        `json:"content" bson:"content"`
+}
+
+// Document is the searchable projection of the latest version of a root.
+type Document struct {
+	Root     bson.ObjectID `json:"root" bson:"root"`
+	PageID   bson.ObjectID `json:"pageid" bson:"page_id"`
+	Title    string        `json:"title" bson:"title"`
+	Abstract string        `json:"abstract,omitempty" bson:"abstract,omitempty"`
+	Tags     []string      `json:"tags,omitempty" bson:"tags,omitempty"`
+	Stanzas  []Passage     `json:"stanzas,omitempty" bson:"stanzas,omitempty"`
+}
+
+type Span struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+type Snippet struct {
+	Stanza     bson.ObjectID `json:"stanza"`
+	Text       string        `json:"text"`
+	Highlights []Span        `json:"highlights,omitempty"`
+}
+
+type Result struct {
+	Root     bson.ObjectID `json:"root"`
+	PageID   bson.ObjectID `json:"pageid"`
+	Title    string        `json:"title"`
+	Score    float64       `json:"score"`
+	Snippets []Snippet     `json:"snippets,omitempty"`
+}
+
+type Query struct {
+	Text  string
+	Limit int
+}
+
+type Index interface {
+	Put(ctx context.Context, doc Document) error
+	Remove(ctx context.Context, root bson.ObjectID) error
+	Search(ctx context.Context, query Query) ([]Result, error)
+}
+
+type token struct {
+	Term  string
+	Start int
+	End   int
+}
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true, "be": true,
+	"by": true, "for": true, "from": true, "in": true, "is": true, "it": true, "of": true,
+	"on": true, "or": true, "that": true, "the": true, "to": true, "was": true, "with": true,
+}
+
+// tokenize lowercases text and splits it on anything that is not a letter or
+// digit, keeping byte offsets into the original text for highlighting.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isWord := unicode.IsLetter(r) || unicode.IsDigit(r)
+		if isWord && start < 0 {
+			start = i
+		}
+		if !isWord && start >= 0 {
+			tokens = appendToken(tokens, text, start, i)
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = appendToken(tokens, text, start, len(text))
+	}
+	return tokens
+}
+
+func appendToken(tokens []token, text string, start int, end int) []token {
+	term := strings.ToLower(text[start:end])
+	if utf8.RuneCountInString(term) < 2 || stopWords[term] {
+		return tokens
+	}
+	return append(tokens, token{Term: term, Start: start, End: end})
+}
+
+// queryTerms returns the unique terms of a query, bounded by MAX_QUERY_TERMS.
+func queryTerms(text string) []string {
+	var terms []string
+	for _, tok := range tokenize(text) {
+		if !slices.Contains(terms, tok.Term) {
+			terms = append(terms, tok.Term)
+		}
+		if len(terms) >= MAX_QUERY_TERMS {
+			break
+		}
+	}
+	return terms
+}
+
+// termWeights is the field weighted term frequency of a document.
+func termWeights(doc Document) map[string]float64 {
+	weights := make(map[string]float64)
+	add := func(text string, weight float64) {
+		for _, tok := range tokenize(text) {
+			weights[tok.Term] += weight
+		}
+	}
+
+	add(doc.Title, TITLE_WEIGHT)
+	add(doc.Abstract, ABSTRACT_WEIGHT)
+	for _, tag := range doc.Tags {
+		add(tag, TAG_WEIGHT)
+	}
+	for _, stanza := range doc.Stanzas {
+		add(stanza.Content, STANZA_WEIGHT)
+	}
+	return weights
+}
+
+func uniqueTerms(weights map[string]float64) []string {
+	terms := make([]string, 0, len(weights))
+	for term := range weights {
+		terms = append(terms, term)
+	}
+	slices.Sort(terms)
+	return terms
+}
+
+type candidate struct {
+	Doc     Document
+	Weights map[string]float64
+}
+
+// rank scores candidates against the query terms. Every term has to be
+// present in a document for it to match. docFreq and total are used for the
+// inverse document frequency so rare terms dominate common ones.
+func rank(candidates []candidate, terms []string, docFreq map[string]int, total int, limit int) []Result {
+	var results []Result
+	for _, c := range candidates {
+		score := 0.0
+		matched := true
+		for _, term := range terms {
+			tf := c.Weights[term]
+			if tf == 0 {
+				matched = false
+				break
+			}
+			df := float64(docFreq[term])
+			idf := math.Log(1 + (float64(total)-df+0.5)/(df+0.5))
+			score += idf * tf * (TERM_SATURATION + 1) / (tf + TERM_SATURATION)
+		}
+
+		if !matched || len(terms) == 0 {
+			continue
+		}
+
+		results = append(results, Result{
+			Root:   c.Doc.Root,
+			PageID: c.Doc.PageID,
+			Title:  c.Doc.Title,
+			Score:  score,
+		})
+	}
+
+	slices.SortFunc(results, func(a, b Result) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return a.Root.Timestamp().Compare(b.Root.Timestamp())
+	})
+
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	return results
+}
+
+// snippets picks the stanzas with the most query term hits and cuts a window
+// of text around the first hit of each, with highlight spans relative to it.
+func snippets(doc Document, terms []string) []Snippet {
+	type hit struct {
+		stanza Passage
+		spans  []Span
+	}
+
+	var hits []hit
+	for _, stanza := range doc.Stanzas {
+		var spans []Span
+		for _, tok := range tokenize(stanza.Content) {
+			if slices.Contains(terms, tok.Term) {
+				spans = append(spans, Span{Start: tok.Start, End: tok.End})
+			}
+		}
+		if len(spans) > 0 {
+			hits = append(hits, hit{stanza: stanza, spans: spans})
+		}
+	}
+
+	slices.SortStableFunc(hits, func(a, b hit) int {
+		return cmp.Compare(len(b.spans), len(a.spans))
+	})
+
+	if len(hits) > MAX_SNIPPETS {
+		hits = hits[:MAX_SNIPPETS]
+	}
+
+	retval := make([]Snippet, 0, len(hits))
+	for _, h := range hits {
+		content := h.stanza.Content
+		start := wordBoundary(content, h.spans[0].Start-SNIPPET_RADIUS, false)
+		end := wordBoundary(content, h.spans[0].End+SNIPPET_RADIUS, true)
+
+		snippet := Snippet{Stanza: h.stanza.ID, Text: content[start:end]}
+		for _, span := range h.spans {
+			if span.Start >= start && span.End <= end {
+				snippet.Highlights = append(snippet.Highlights, Span{Start: span.Start - start, End: span.End - start})
+			}
+		}
+		retval = append(retval, snippet)
+	}
+	return retval
+}
+
+// wordBoundary clamps pos into text and moves it onto a whitespace boundary
+// (forward when forward is set) so snippets do not cut words or runes.
+func wordBoundary(text string, pos int, forward bool) int {
+	if pos <= 0 {
+		return 0
+	}
+	if pos >= len(text) {
+		return len(text)
+	}
+
+	if forward {
+		for pos < len(text) && !isSpaceAt(text, pos) {
+			pos++
+		}
+		return pos
+	}
+
+	for pos > 0 && !isSpaceAt(text, pos-1) {
+		pos--
+	}
+	return pos
+}
+
+func isSpaceAt(text string, pos int) bool {
+	r, _ := utf8.DecodeRuneInString(text[pos:])
+	return r != utf8.RuneError && unicode.IsSpace(r)
+}
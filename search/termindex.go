@@ -0,0 +1,141 @@
+package search
+
+import (
+	"context"
+	"time"
+
+	"git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_CANDIDATES int64 = 500
+
+// SearchEntry is the stored form of a Document. Terms carries the unique
+// indexed terms and is expected to have a multikey index; removed entries are
+// emptied and expired through the ttl_start TTL index. TtlStart is written as
+// null by Put, so that a root indexed again after removal does not expire.
+type SearchEntry struct {
+	kosmos.BaseModel `bson:",inline" kosmos:"search_entry"`
+	Document         `bson:",inline"`
+	Terms            []string   `bson:"terms"`
+	TtlStart         *time.Time `bson:"ttl_start"`
+}
+
+// entryCount is the number of entries matching a count query.
+type entryCount struct {
+	Count int `bson:"count"`
+}
+
+func (c entryCount) GetID() bson.ObjectID {
+	return bson.NilObjectID
+}
+
+func (c entryCount) HasID() bool {
+	return false
+}
+
+func (c entryCount) LastObserved() time.Time {
+	return time.Time{}
+}
+
+// TermIndex keeps one SearchEntry per root in Mongo. Matching is an equality
+// match on the stored terms array and ranking happens in process over the
+// matched candidates, with document frequencies counted over the whole index,
+// one count per query term.
+//
+// It is not a Mongo text index backend: one creating a $text index over the
+// indexed fields and ranking with textScore is still outstanding.
+type TermIndex struct{}
+
+func NewTermIndex() *TermIndex {
+	return &TermIndex{}
+}
+
+func (m *TermIndex) Put(ctx context.Context, doc Document) error {
+	entry := SearchEntry{
+		Document: doc,
+		Terms:    uniqueTerms(termWeights(doc)),
+		TtlStart: nil, // clears the expiry of a removed entry
+	}
+	entry.ID = doc.Root
+	return kosmos.Record(ctx, &entry)
+}
+
+func (m *TermIndex) Remove(ctx context.Context, root bson.ObjectID) error {
+	now := time.Now().UTC()
+	entry := SearchEntry{
+		Document: Document{Root: root},
+		Terms:    []string{},
+		TtlStart: &now,
+	}
+	entry.ID = root
+	return kosmos.Record(ctx, &entry)
+}
+
+func (m *TermIndex) Search(ctx context.Context, query Query) ([]Result, error) {
+	terms := queryTerms(query.Text)
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	predicates := make([]expression.QueryFieldPredicate, 0, len(terms))
+	for _, term := range terms {
+		predicates = append(predicates, kosmos.Fld("terms").Eq(term))
+	}
+
+	// newest roots first when more entries match than can be ranked
+	entries, err := kosmos.Detect[SearchEntry](
+		kosmos.And(predicates...),
+	).Sort(kosmos.Fld("ID").Desc()).Limit(MAX_CANDIDATES).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	total, err := countEntries(ctx, kosmos.Fld("ttl_start").Eq(nil))
+	if err != nil {
+		return nil, err
+	}
+
+	docFreq := make(map[string]int, len(terms))
+	for _, term := range terms {
+		docFreq[term], err = countEntries(ctx, kosmos.Fld("terms").Eq(term))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	candidates := make([]candidate, 0, len(entries))
+	for _, entry := range entries {
+		candidates = append(candidates, candidate{Doc: entry.Document, Weights: termWeights(entry.Document)})
+	}
+
+	results := rank(candidates, terms, docFreq, total, query.Limit)
+	docs := make(map[bson.ObjectID]Document, len(candidates))
+	for _, c := range candidates {
+		docs[c.Doc.Root] = c.Doc
+	}
+	for i := range results {
+		results[i].Snippets = snippets(docs[results[i].Root], terms)
+	}
+	return results, nil
+}
+
+// countEntries counts the entries matching predicate in a single aggregation.
+func countEntries(ctx context.Context, predicate expression.QueryFieldPredicate) (int, error) {
+	counts, err := kosmos.ProjectInto[entryCount](
+		kosmos.Fld("count").With().One(),
+	).From(
+		kosmos.Detect[SearchEntry](predicate).GroupBy().Accumulate(
+			kosmos.Fld("count").With().Sum(1),
+		),
+	).PullAll(ctx)
+	if err != nil || len(counts) == 0 {
+		return 0, err
+	}
+	return counts[0].Count, nil
+}
@@ -69,10 +69,13 @@ type Comment struct {
 	UserName         string        `xml:"username,omitempty" json:"username,omitempty" bson:"user_name,omitempty"`
 	Moment           string        `xml:"moment" json:"moment" bson:"moment"`
 	Content          string        `xml:"content" json:"content" bson:"content"`
+	State            string        `xml:"state,omitempty" json:"state,omitempty" bson:"state,omitempty"`
 	Infos            MetaInfo      `xml:"infos,omitempty" json:"Infos" bson:"infos,omitempty"`
 	Score            float64       `xml:"-" json:"-" bson:"score"`
+	Controversy      float64       `xml:"-" json:"-" bson:"controversy"`
 	BestBy           time.Time     `xml:"-" json:"-" bson:"best_by"`
 	EventAt          time.Time     `xml:"eventat" json:"eventat" bson:"event_at"`
+	EditedAt         time.Time     `xml:"editedat,omitempty" json:"editedat,omitzero" bson:"edited_at,omitempty"`
 	TtlStart         time.Time     `xml:"-" json:"-" bson:"ttl_start,omitempty"`
 }
 
@@ -0,0 +1,444 @@
+package topic
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"math"
+	"slices"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Activity is recorded into hourly buckets per root. Hourly buckets of days
+// older than ACTIVITY_HOURLY_RETENTION are rolled up into daily buckets by CompactActivity
+// and then expire through the ttl_start TTL index.
+
+var ACTIVITY_HOURLY_RETENTION = 48 * time.Hour
+var MAX_ACTIVITY_BUCKETS int64 = 20000
+
+var TRENDING_RECENT = 6 * time.Hour
+var TRENDING_BASELINE = 7 * 24 * time.Hour
+
+// TRENDING_PRIOR is a baseline rate (events per hour) added to every root so a
+// single event on a quiet root does not outrank steady activity elsewhere.
+var TRENDING_PRIOR = 1.0 / 24.0
+
+const (
+	ActivityHour = "hour"
+	ActivityDay  = "day"
+)
+
+type ActivityKind int
+
+const (
+	ActivityComment ActivityKind = iota
+	ActivityVersion
+	ActivityRelation
+)
+
+type ActivityWeights struct {
+	Comment  float64 `json:"comment"`
+	Version  float64 `json:"version"`
+	Relation float64 `json:"relation"`
+}
+
+var DefaultActivityWeights = ActivityWeights{Comment: 1.0, Version: 2.0, Relation: 0.5}
+
+type ActivityBucket struct {
+	km.BaseModel `bson:",inline" kosmos:"page_activity"`
+	XMLName      xml.Name      `xml:"page_activity" json:"-" bson:"-"`
+	Root         bson.ObjectID `xml:"root" json:"root" bson:"root"`
+	Span         string        `xml:"span" json:"span" bson:"span"`
+	Start        time.Time     `xml:"start" json:"start" bson:"start"`
+	Comments     int           `xml:"comments" json:"comments" bson:"comments,omitempty"`
+	Versions     int           `xml:"versions" json:"versions" bson:"versions,omitempty"`
+	Relations    int           `xml:"relations" json:"relations" bson:"relations,omitempty"`
+	Compacted    bool          `xml:"-" json:"-" bson:"compacted,omitempty"`
+	TtlStart     time.Time     `xml:"-" json:"-" bson:"ttl_start,omitempty"`
+	commentIncr  int
+	versionIncr  int
+	relationIncr int
+}
+
+func NewActivityBucket(root bson.ObjectID, span string, at time.Time) ActivityBucket {
+	start := at.UTC().Truncate(time.Hour)
+	if span == ActivityDay {
+		start = at.UTC().Truncate(24 * time.Hour)
+	}
+	return ActivityBucket{Root: root, Span: span, Start: start}
+}
+
+func (a ActivityBucket) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		km.Fld("Root").Eq(a.Root),
+		km.Fld("Span").Eq(a.Span),
+		km.Fld("Start").Eq(a.Start),
+	)
+}
+
+func (a *ActivityBucket) Incr(kind ActivityKind, n int) {
+	switch kind {
+	case ActivityComment:
+		a.commentIncr += n
+		a.Comments += n
+	case ActivityVersion:
+		a.versionIncr += n
+		a.Versions += n
+	case ActivityRelation:
+		a.relationIncr += n
+		a.Relations += n
+	}
+}
+
+func (a ActivityBucket) Weighted(weights ActivityWeights) float64 {
+	return weights.Comment*float64(a.Comments) + weights.Version*float64(a.Versions) + weights.Relation*float64(a.Relations)
+}
+
+func (a *ActivityBucket) Collapse() matter.Ripple {
+	ripple := a.BaseModel.Collapse()
+	ripple.Set("comments", a.Comments)
+	ripple.Set("versions", a.Versions)
+	ripple.Set("relations", a.Relations)
+
+	ripple.DoIncr("comments", a.commentIncr)
+	ripple.DoIncr("versions", a.versionIncr)
+	ripple.DoIncr("relations", a.relationIncr)
+
+	a.Comments = 0
+	a.Versions = 0
+	a.Relations = 0
+	return ripple
+}
+
+func (a *ActivityBucket) Decohere(ripple matter.Ripple) error {
+	if count, ok := ripple.Get("comments"); ok {
+		a.Comments = count.(int)
+	}
+	if count, ok := ripple.Get("versions"); ok {
+		a.Versions = count.(int)
+	}
+	if count, ok := ripple.Get("relations"); ok {
+		a.Relations = count.(int)
+	}
+
+	a.commentIncr = 0
+	a.versionIncr = 0
+	a.relationIncr = 0
+
+	return a.BaseModel.Decohere(ripple)
+}
+
+// RecordActivity adds one event of kind to the hourly bucket of root.
+func RecordActivity(ctx context.Context, root bson.ObjectID, kind ActivityKind) error {
+	if root.IsZero() {
+		return nil
+	}
+
+	bucket := NewActivityBucket(root, ActivityHour, time.Now())
+	bucket.Incr(kind, 1)
+	return km.Record(ctx, &bucket)
+}
+
+// rollupActivity sums hourly buckets into daily buckets per root.
+func rollupActivity(hourly []ActivityBucket) []ActivityBucket {
+	type key struct {
+		root bson.ObjectID
+		day  time.Time
+	}
+
+	daily := make(map[key]*ActivityBucket)
+	var order []key
+	for _, bucket := range hourly {
+		k := key{bucket.Root, bucket.Start.UTC().Truncate(24 * time.Hour)}
+		day, ok := daily[k]
+		if !ok {
+			newDay := NewActivityBucket(bucket.Root, ActivityDay, bucket.Start)
+			day = &newDay
+			daily[k] = day
+			order = append(order, k)
+		}
+		day.Incr(ActivityComment, bucket.Comments)
+		day.Incr(ActivityVersion, bucket.Versions)
+		day.Incr(ActivityRelation, bucket.Relations)
+	}
+
+	retval := make([]ActivityBucket, 0, len(order))
+	for _, k := range order {
+		retval = append(retval, *daily[k])
+	}
+	return retval
+}
+
+// activityRollup records the totals of a daily bucket, set rather than
+// incremented, so that recording the same day again leaves it unchanged.
+type activityRollup struct {
+	km.BaseModel `bson:",inline" kosmos:"page_activity"`
+	Root         bson.ObjectID `bson:"root"`
+	Span         string        `bson:"span"`
+	Start        time.Time     `bson:"start"`
+	Comments     int           `bson:"comments"`
+	Versions     int           `bson:"versions"`
+	Relations    int           `bson:"relations"`
+}
+
+// SelfScope keys the rollup by root and day, the same daily bucket every time.
+func (a activityRollup) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		km.Fld("Root").Eq(a.Root),
+		km.Fld("Span").Eq(a.Span),
+		km.Fld("Start").Eq(a.Start),
+	)
+}
+
+// compactActivityDay recomputes the daily bucket of root from all of its
+// hourly buckets of that day, compacted or not, and only then marks them for
+// expiry. It returns the number of hourly buckets newly marked.
+func compactActivityDay(ctx context.Context, root bson.ObjectID, day time.Time, now time.Time) (int, error) {
+	hourly, err := km.Detect[ActivityBucket](
+		km.Fld("Root").Eq(root),
+		km.Fld("Span").Eq(ActivityHour),
+		km.Fld("Start").Gte(day),
+		km.Fld("Start").Lt(day.Add(24*time.Hour)),
+	).Limit(24).PullAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, daily := range rollupActivity(hourly) {
+		rollup := activityRollup{
+			Root:      daily.Root,
+			Span:      daily.Span,
+			Start:     daily.Start,
+			Comments:  daily.Comments,
+			Versions:  daily.Versions,
+			Relations: daily.Relations,
+		}
+		if err := km.Record(ctx, &rollup); err != nil {
+			return 0, fmt.Errorf("failed to record daily bucket %v", err)
+		}
+	}
+
+	marked := 0
+	for _, bucket := range hourly {
+		if bucket.Compacted {
+			continue
+		}
+		bucket.Compacted = true
+		bucket.TtlStart = now
+		if err := km.Record(ctx, &bucket); err != nil {
+			return marked, fmt.Errorf("failed to mark hourly bucket %v", err)
+		}
+		marked++
+	}
+	return marked, nil
+}
+
+// CompactActivity rolls hourly buckets of the whole days older than
+// ACTIVITY_HOURLY_RETENTION into daily buckets, oldest first in batches of
+// MAX_ACTIVITY_BUCKETS, and marks them for expiry. Each daily bucket is set
+// from all hourly buckets of its day before they are marked, so a retry after
+// a failure recomputes the same totals instead of counting them twice. The TTL
+// on ttl_start must outlast the compaction interval for the retry to find them.
+// It returns the number of hourly buckets compacted.
+func CompactActivity(ctx context.Context, now time.Time) (int, error) {
+	// only whole days, so that every hourly bucket of a day is compacted
+	// together and the daily totals are computed once over all of them
+	cutoff := now.Add(-ACTIVITY_HOURLY_RETENTION).UTC().Truncate(24 * time.Hour)
+
+	compacted := 0
+	for {
+		hourly, err := km.Detect[ActivityBucket](
+			km.Fld("Span").Eq(ActivityHour),
+			km.Fld("Start").Lt(cutoff),
+			km.Fld("compacted").Ne(true),
+		).Sort(km.Fld("Start").Asc(), km.Fld("ID").Asc()).Limit(MAX_ACTIVITY_BUCKETS).PullAll(ctx)
+		if err != nil {
+			return compacted, err
+		}
+
+		for _, day := range rollupActivity(hourly) {
+			marked, err := compactActivityDay(ctx, day.Root, day.Start, now)
+			compacted += marked
+			if err != nil {
+				return compacted, fmt.Errorf("CompactActivity: %v", err)
+			}
+		}
+
+		if int64(len(hourly)) < MAX_ACTIVITY_BUCKETS || ctx.Err() != nil {
+			return compacted, ctx.Err()
+		}
+	}
+}
+
+// RunActivityCompaction compacts activity every interval until ctx is done.
+func RunActivityCompaction(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			count, err := CompactActivity(ctx, now.UTC())
+			if err != nil {
+				log.Printf("Activity compaction failed: %v", err)
+				continue
+			}
+			log.Printf("Activity compaction rolled up %d hourly buckets", count)
+		}
+	}
+}
+
+type Trend struct {
+	Root         bson.ObjectID `json:"root"`
+	RecentRate   float64       `json:"recentrate"`
+	BaselineRate float64       `json:"baselinerate"`
+	Score        float64       `json:"score"`
+}
+
+// TrendScore compares the recent hourly rate of activity against the baseline
+// rate, scaled down by the baseline's magnitude so that busy roots need a
+// proportionally larger jump to trend.
+func TrendScore(recentRate float64, baselineRate float64) float64 {
+	return (recentRate - baselineRate) / math.Sqrt(baselineRate+TRENDING_PRIOR)
+}
+
+// trendingWindows returns the start of the recent window and of the baseline
+// window before it. The baseline starts on a day boundary so that it covers
+// whole daily buckets.
+func trendingWindows(now time.Time) (time.Time, time.Time) {
+	recentStart := now.Add(-TRENDING_RECENT)
+	return recentStart, recentStart.Add(-TRENDING_BASELINE).Truncate(24 * time.Hour)
+}
+
+// trendsFromBuckets scores the roots of the recent buckets against their
+// baseline buckets. Rates are per hour of the actual span of each window.
+func trendsFromBuckets(recentBuckets []ActivityBucket, baselineBuckets []ActivityBucket, now time.Time, weights ActivityWeights) []Trend {
+	recentStart, baselineStart := trendingWindows(now)
+
+	recent := make(map[bson.ObjectID]float64)
+	for _, bucket := range recentBuckets {
+		recent[bucket.Root] += bucket.Weighted(weights)
+	}
+
+	baseline := make(map[bson.ObjectID]float64)
+	for _, bucket := range baselineBuckets {
+		baseline[bucket.Root] += bucket.Weighted(weights)
+	}
+
+	recentHours := now.Sub(recentStart).Hours()
+	baselineHours := recentStart.Sub(baselineStart).Hours()
+
+	var trends []Trend
+	for root, activity := range recent {
+		trend := Trend{
+			Root:         root,
+			RecentRate:   activity / recentHours,
+			BaselineRate: baseline[root] / baselineHours,
+		}
+		trend.Score = TrendScore(trend.RecentRate, trend.BaselineRate)
+		if trend.Score > 0 {
+			trends = append(trends, trend)
+		}
+	}
+
+	slices.SortFunc(trends, func(a, b Trend) int {
+		return cmp.Compare(b.Score, a.Score)
+	})
+	return trends
+}
+
+// activityTotals sums per root the buckets starting within [since, until).
+// Compacted hourly buckets are left out as their daily bucket counts them.
+func activityTotals(ctx context.Context, since time.Time, until time.Time) ([]ActivityBucket, error) {
+	return km.ProjectInto[ActivityBucket](
+		km.Fld("root").With().GroupKey("root"),
+		km.Fld("comments").With().One(),
+		km.Fld("versions").With().One(),
+		km.Fld("relations").With().One(),
+	).From(
+		km.Detect[ActivityBucket](
+			km.Fld("Start").Gte(since),
+			km.Fld("Start").Lt(until),
+			km.Fld("compacted").Ne(true),
+		).GroupBy(
+			"root",
+		).Accumulate(
+			km.Fld("comments").With().Sum("comments"),
+			km.Fld("versions").With().Sum("versions"),
+			km.Fld("relations").With().Sum("relations"),
+		),
+	).PullAll(ctx)
+}
+
+func TrendingRoots(ctx context.Context, now time.Time, weights ActivityWeights) ([]Trend, error) {
+	recentStart, baselineStart := trendingWindows(now)
+	recent, err := activityTotals(ctx, recentStart, now.Add(time.Hour))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(recent) == 0 {
+		return nil, nil
+	}
+
+	baseline, err := activityTotals(ctx, baselineStart, recentStart)
+	if err != nil {
+		return nil, err
+	}
+
+	return trendsFromBuckets(recent, baseline, now, weights), nil
+}
+
+func PullTrendingRoots(limit int) HandlerFunc[PageStat] {
+	return func(s *Session[PageStat]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Trending Session missing Response structure")
+		}
+
+		ctx := s.Request.Context()
+		trends, err := TrendingRoots(ctx, time.Now().UTC(), DefaultActivityWeights)
+		if err != nil {
+			return fmt.Errorf("TrendingRoots request error: %v", err)
+		}
+
+		if len(trends) > limit {
+			trends = trends[:limit]
+		}
+
+		if len(trends) == 0 {
+			return nil
+		}
+
+		roots := make([]bson.ObjectID, 0, len(trends))
+		for _, trend := range trends {
+			roots = append(roots, trend.Root)
+		}
+
+		pageStats, err := km.Detect[PageStat](km.Fld("ID").In(roots)).Limit(int64(len(roots))).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("Trending PageStat request error: %v", err)
+		}
+
+		byRoot := make(map[bson.ObjectID]PageStat, len(pageStats))
+		for _, stat := range pageStats {
+			byRoot[stat.ID] = stat
+		}
+
+		for _, root := range roots {
+			stat, ok := byRoot[root]
+			if ok {
+				s.Response.Append(stat)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,72 @@
+package topic
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestRollupActivity(t *testing.T) {
+	root := bson.NewObjectID()
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+
+	first := NewActivityBucket(root, ActivityHour, day.Add(3*time.Hour+15*time.Minute))
+	first.Incr(ActivityComment, 2)
+	second := NewActivityBucket(root, ActivityHour, day.Add(20*time.Hour))
+	second.Incr(ActivityVersion, 1)
+	nextDay := NewActivityBucket(root, ActivityHour, day.Add(26*time.Hour))
+	nextDay.Incr(ActivityRelation, 4)
+
+	if !first.Start.Equal(day.Add(3 * time.Hour)) {
+		t.Errorf("expected hourly bucket to be truncated to the hour, got %v", first.Start)
+	}
+
+	daily := rollupActivity([]ActivityBucket{first, second, nextDay})
+	if len(daily) != 2 {
+		t.Fatalf("expected 2 daily buckets, got %d", len(daily))
+	}
+
+	if daily[0].Span != ActivityDay || !daily[0].Start.Equal(day) {
+		t.Errorf("expected first daily bucket to start at %v, got %v", day, daily[0].Start)
+	}
+	if daily[0].Comments != 2 || daily[0].Versions != 1 || daily[0].Relations != 0 {
+		t.Errorf("unexpected daily totals %+v", daily[0])
+	}
+	if daily[1].Relations != 4 {
+		t.Errorf("expected relations on the next day, got %+v", daily[1])
+	}
+}
+
+func TestTrendsFromBuckets(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	steady := bson.NewObjectID()
+	rising := bson.NewObjectID()
+
+	var baseline []ActivityBucket
+	for d := 1; d <= 7; d++ {
+		bucket := NewActivityBucket(steady, ActivityDay, now.AddDate(0, 0, -d))
+		bucket.Incr(ActivityComment, 24)
+		baseline = append(baseline, bucket)
+	}
+
+	// the baseline runs from the start of its first day to the recent window
+	early := NewActivityBucket(steady, ActivityHour, now.Add(-9*time.Hour))
+	early.Incr(ActivityComment, 6)
+	baseline = append(baseline, early)
+
+	recentSteady := NewActivityBucket(steady, ActivityHour, now.Add(-time.Hour))
+	recentSteady.Incr(ActivityComment, 6)
+	recentRising := NewActivityBucket(rising, ActivityHour, now.Add(-time.Hour))
+	recentRising.Incr(ActivityComment, 6)
+	recent := []ActivityBucket{recentSteady, recentRising}
+
+	trends := trendsFromBuckets(recent, baseline, now, DefaultActivityWeights)
+	if len(trends) != 1 {
+		t.Fatalf("expected only the rising root to trend, got %v", trends)
+	}
+
+	if trends[0].Root != rising || trends[0].RecentRate != 1.0 {
+		t.Errorf("unexpected trend %+v", trends[0])
+	}
+}
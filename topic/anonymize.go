@@ -0,0 +1,309 @@
+package topic
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// PseudonymKey keys the pseudonyms of anonymized users so they cannot be
+// recomputed from a user ID alone. AnonymizeUser refuses to run without it.
+var PseudonymKey []byte
+
+// ANONYMIZE_BATCH is how many documents AnonymizeUser reads per query.
+var ANONYMIZE_BATCH int64 = 1000
+
+// Pseudonym is the stable replacement name of an anonymized user.
+func Pseudonym(userId bson.ObjectID) string {
+	mac := hmac.New(sha256.New, PseudonymKey)
+	mac.Write(userId[:])
+	return "anon-" + hex.EncodeToString(mac.Sum(nil)[:6])
+}
+
+type AnonymizeChange struct {
+	Collection string        `json:"collection"`
+	ID         bson.ObjectID `json:"id"`
+	Field      string        `json:"field"`
+	From       string        `json:"from,omitempty"`
+	To         string        `json:"to,omitempty"`
+}
+
+type AnonymizeReport struct {
+	UserId    bson.ObjectID     `json:"userid"`
+	Pseudonym string            `json:"pseudonym"`
+	DryRun    bool              `json:"dryrun"`
+	Changes   []AnonymizeChange `json:"changes"`
+	Stats     []ReconcileReport `json:"stats"`
+}
+
+func (r *AnonymizeReport) add(collection string, id bson.ObjectID, field string, from string, to string) {
+	change := AnonymizeChange{Collection: collection, ID: id, Field: field, From: from, To: to}
+	r.Changes = append(r.Changes, change)
+	log.Printf("Anonymize %s (dry run %v): %s %s %s %q -> %q", r.UserId.Hex(), r.DryRun, collection, id.Hex(), field, from, to)
+}
+
+// The partial models below rewrite the identity fields only, leaving content
+// and the Decohere side effects of the full models untouched.
+
+type pageAuthor struct {
+	km.BaseModel `bson:",inline" kosmos:"page"`
+	Author       string `bson:"author"`
+}
+
+type commentUserName struct {
+	km.BaseModel `bson:",inline" kosmos:"comment"`
+	UserName     string `bson:"user_name"`
+}
+
+type commentRevisionUserName struct {
+	km.BaseModel `bson:",inline" kosmos:"comment_revision"`
+	UserName     string `bson:"user_name"`
+}
+
+type notificationActor struct {
+	km.BaseModel `bson:",inline" kosmos:"notification"`
+	Actor        string `bson:"actor"`
+}
+
+type notificationRecipient struct {
+	km.BaseModel `bson:",inline" kosmos:"notification"`
+	Recipient    string `bson:"recipient"`
+}
+
+type authorStatName struct {
+	km.BaseModel `bson:",inline" kosmos:"author_stat"`
+	Name         string `bson:"name"`
+}
+
+type authorActivityName struct {
+	km.BaseModel `bson:",inline" kosmos:"author_activity"`
+	Name         string `bson:"name"`
+}
+
+type readingListDeletion struct {
+	km.BaseModel `bson:",inline" kosmos:"reading_list"`
+	Owner        string    `bson:"owner"`
+	Deleted      bool      `bson:"deleted"`
+	TtlStart     time.Time `bson:"ttl_start"`
+}
+
+// AnonymizeUser replaces the name of a deleted user with its pseudonym in
+// pages, comments and author stats, deactivates the user's relations, removes
+// their reading lists and recomputes the PageStat of every root they authored
+// or commented on.
+// With dryRun nothing is written and the report lists what would change.
+func AnonymizeUser(ctx context.Context, userId bson.ObjectID, userName string, dryRun bool) (*AnonymizeReport, error) {
+	if userId.IsZero() {
+		return nil, fmt.Errorf("AnonymizeUser: missing user id")
+	}
+
+	if len(PseudonymKey) == 0 {
+		return nil, fmt.Errorf("AnonymizeUser: missing pseudonym key")
+	}
+
+	pseudonym := Pseudonym(userId)
+	report := &AnonymizeReport{UserId: userId, Pseudonym: pseudonym, DryRun: dryRun}
+	record := func(model any) error {
+		if dryRun {
+			return nil
+		}
+		return km.Record(ctx, model)
+	}
+
+	roots := make(map[bson.ObjectID]bool)
+	if userName != "" && userName != pseudonym {
+		err := eachDetected(ctx, func(page sitepages.Page) error {
+			update := pageAuthor{Author: pseudonym}
+			update.ID = page.ID
+			if err := record(&update); err != nil {
+				return fmt.Errorf("page %s: %v", page.ID.Hex(), err)
+			}
+			report.add("page", page.ID, "author", userName, pseudonym)
+			roots[page.Root] = true
+			return nil
+		}, km.Fld("author").Eq(userName))
+		if err != nil {
+			return report, fmt.Errorf("AnonymizeUser pages: %v", err)
+		}
+
+		err = eachDetected(ctx, func(comment sitepages.Comment) error {
+			update := commentUserName{UserName: pseudonym}
+			update.ID = comment.ID
+			if err := record(&update); err != nil {
+				return fmt.Errorf("comment %s: %v", comment.ID.Hex(), err)
+			}
+			report.add("comment", comment.ID, "user_name", userName, pseudonym)
+			roots[comment.Root] = true
+			return nil
+		}, km.Fld("user_name").Eq(userName))
+		if err != nil {
+			return report, fmt.Errorf("AnonymizeUser comments: %v", err)
+		}
+
+		err = eachDetected(ctx, func(revision CommentRevision) error {
+			update := commentRevisionUserName{UserName: pseudonym}
+			update.ID = revision.ID
+			if err := record(&update); err != nil {
+				return fmt.Errorf("comment revision %s: %v", revision.ID.Hex(), err)
+			}
+			report.add("comment_revision", revision.ID, "user_name", userName, pseudonym)
+			return nil
+		}, km.Fld("user_name").Eq(userName))
+		if err != nil {
+			return report, fmt.Errorf("AnonymizeUser comment revisions: %v", err)
+		}
+
+		err = eachDetected(ctx, func(notification Notification) error {
+			update := notificationActor{Actor: pseudonym}
+			update.ID = notification.ID
+			if err := record(&update); err != nil {
+				return fmt.Errorf("notification %s: %v", notification.ID.Hex(), err)
+			}
+			report.add("notification", notification.ID, "actor", userName, pseudonym)
+			return nil
+		}, km.Fld("actor").Eq(userName))
+		if err != nil {
+			return report, fmt.Errorf("AnonymizeUser notifications: %v", err)
+		}
+
+		err = eachDetected(ctx, func(notification Notification) error {
+			update := notificationRecipient{Recipient: pseudonym}
+			update.ID = notification.ID
+			if err := record(&update); err != nil {
+				return fmt.Errorf("notification %s: %v", notification.ID.Hex(), err)
+			}
+			report.add("notification", notification.ID, "recipient", userName, pseudonym)
+			return nil
+		}, km.Fld("recipient").Eq(userName))
+		if err != nil {
+			return report, fmt.Errorf("AnonymizeUser notifications: %v", err)
+		}
+
+		err = eachDetected(ctx, func(stat AuthorStat) error {
+			update := authorStatName{Name: pseudonym}
+			update.ID = stat.ID
+			if err := record(&update); err != nil {
+				return fmt.Errorf("author stat %s: %v", stat.ID.Hex(), err)
+			}
+			report.add("author_stat", stat.ID, "name", userName, pseudonym)
+			return nil
+		}, km.Fld("Name").Eq(userName))
+		if err != nil {
+			return report, fmt.Errorf("AnonymizeUser author stat: %v", err)
+		}
+
+		err = eachDetected(ctx, func(activity AuthorActivity) error {
+			update := authorActivityName{Name: pseudonym}
+			update.ID = activity.ID
+			if err := record(&update); err != nil {
+				return fmt.Errorf("author activity %s: %v", activity.ID.Hex(), err)
+			}
+			report.add("author_activity", activity.ID, "name", userName, pseudonym)
+			return nil
+		}, km.Fld("Name").Eq(userName))
+		if err != nil {
+			return report, fmt.Errorf("AnonymizeUser author activity: %v", err)
+		}
+	}
+
+	if err := deactivateLinks[UserToPageLink](ctx, userId, report, record); err != nil {
+		return report, err
+	}
+	if err := deactivateLinks[UserToCommentLink](ctx, userId, report, record); err != nil {
+		return report, err
+	}
+	if err := deactivateLinks[UserToRootLink](ctx, userId, report, record); err != nil {
+		return report, err
+	}
+	if err := deactivateLinks[UserToAuthorLink](ctx, userId, report, record); err != nil {
+		return report, err
+	}
+
+	err := eachDetected(ctx, func(list ReadingList) error {
+		update := readingListDeletion{Owner: pseudonym, Deleted: true, TtlStart: time.Now()}
+		update.ID = list.ID
+		if err := record(&update); err != nil {
+			return fmt.Errorf("list %s: %v", list.ID.Hex(), err)
+		}
+		report.add("reading_list", list.ID, "deleted", list.Owner, pseudonym)
+		return nil
+	}, km.Fld("OwnerId").Eq(userId), km.Fld("deleted").Ne(true))
+	if err != nil {
+		return report, fmt.Errorf("AnonymizeUser lists: %v", err)
+	}
+
+	// in a dry run the pages still carry the name: the stats are only checked
+	for root := range roots {
+		stat, err := ReconcilePageStat(ctx, root, !dryRun)
+		if err != nil {
+			return report, fmt.Errorf("AnonymizeUser page stat %s: %v", root.Hex(), err)
+		}
+		report.Stats = append(report.Stats, *stat)
+		if !dryRun {
+			invalidateFeed(ctx, cacheRootTag(root))
+		}
+	}
+
+	if !dryRun {
+		invalidateFeed(ctx, cacheFeedTag)
+	}
+	return report, nil
+}
+
+// deactivateLinks deactivates every active relation held by the user.
+func deactivateLinks[T matter.Detectable, PT relationLink[T]](ctx context.Context, userId bson.ObjectID, report *AnonymizeReport, record func(any) error) error {
+	err := eachDetected(ctx, func(link T) error {
+		ptr := PT(&link)
+		ptr.Link().State = RelationInactive
+		if err := record(ptr); err != nil {
+			return fmt.Errorf("relation %s: %v", ptr.GetID().Hex(), err)
+		}
+		report.add(ptr.ObjectKind()+"_relation", ptr.GetID(), "state", RelationActive, RelationInactive)
+		return nil
+	}, km.Fld("subjid").Eq(userId), km.Fld("state").Eq(RelationActive))
+	if err != nil {
+		return fmt.Errorf("AnonymizeUser relations: %v", err)
+	}
+	return nil
+}
+
+// eachDetected visits every document matching predicates in ID order, reading
+// ANONYMIZE_BATCH at a time after the last ID seen. Paging by ID, rather than
+// until nothing matches, also ends when a dry run leaves the documents as
+// they are.
+func eachDetected[T matter.Detectable](ctx context.Context, visit func(T) error, predicates ...expression.QueryFieldPredicate) error {
+	var last bson.ObjectID
+	for {
+		batch := predicates
+		if !last.IsZero() {
+			batch = append(slices.Clip(predicates), km.Fld("ID").Gt(last))
+		}
+
+		results, err := km.Detect[T](batch...).Sort(km.Fld("ID").Asc()).Limit(ANONYMIZE_BATCH).PullAll(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, result := range results {
+			if err := visit(result); err != nil {
+				return err
+			}
+			last = result.GetID()
+		}
+
+		if int64(len(results)) < ANONYMIZE_BATCH {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package topic
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPseudonym(t *testing.T) {
+	ada, bob := bson.NewObjectID(), bson.NewObjectID()
+
+	if Pseudonym(ada) != Pseudonym(ada) {
+		t.Errorf("expected pseudonym to be stable")
+	}
+	if Pseudonym(ada) == Pseudonym(bob) {
+		t.Errorf("expected distinct users to get distinct pseudonyms")
+	}
+	if !strings.HasPrefix(Pseudonym(ada), "anon-") || strings.Contains(Pseudonym(ada), ada.Hex()) {
+		t.Errorf("unexpected pseudonym %q", Pseudonym(ada))
+	}
+
+	saved := PseudonymKey
+	defer func() { PseudonymKey = saved }()
+	unkeyed := Pseudonym(ada)
+	PseudonymKey = []byte("secret")
+	if Pseudonym(ada) == unkeyed {
+		t.Errorf("expected the key to change the pseudonym")
+	}
+}
+
+func TestAnonymizeUserRequiresKey(t *testing.T) {
+	saved := PseudonymKey
+	defer func() { PseudonymKey = saved }()
+
+	PseudonymKey = nil
+	if _, err := AnonymizeUser(context.Background(), bson.NewObjectID(), "ada", true); err == nil {
+		t.Errorf("expected AnonymizeUser to refuse an empty pseudonym key")
+	}
+}
+
+func TestAnonymizedCommentRootStat(t *testing.T) {
+	root := bson.NewObjectID()
+	pseudonym := Pseudonym(bson.NewObjectID())
+
+	// a root the user only commented on, its stat listing them by mistake
+	stored := PageStat{CommentCount: 1, Authors: []string{"bob", pseudonym}}
+	actual := pageStatFromRows(root, []rootCount{{Root: root, Count: 1}}, []authorRow{{Author: "bob"}}, nil)
+	if slices.Contains(actual.Authors, pseudonym) || actual.CommentCount != 1 {
+		t.Errorf("expected the commenter's pseudonym not among the authors, got %+v", actual)
+	}
+
+	discrepancies := comparePageStat(stored, actual)
+	if len(discrepancies) != 1 || discrepancies[0].Field != "authors" {
+		t.Errorf("expected the recomputed stat to drop the pseudonym, got %v", discrepancies)
+	}
+}
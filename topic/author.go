@@ -0,0 +1,306 @@
+package topic
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"github.com/borghives/sitepages"
+)
+
+var MAX_AUTHOR_ACTIVITY int64 = 20000
+
+type AuthorContribution int
+
+const (
+	AuthorVersion AuthorContribution = iota
+	AuthorStanza
+	AuthorComment
+	AuthorRelationReceived
+)
+
+type AuthorCounts struct {
+	Versions          int `xml:"versions" json:"versions" bson:"versions,omitempty"`
+	Stanzas           int `xml:"stanzas" json:"stanzas" bson:"stanzas,omitempty"`
+	Comments          int `xml:"comments" json:"comments" bson:"comments,omitempty"`
+	RelationsReceived int `xml:"relationsreceived" json:"relationsreceived" bson:"relations_received,omitempty"`
+}
+
+func (a *AuthorCounts) Add(kind AuthorContribution, n int) {
+	switch kind {
+	case AuthorVersion:
+		a.Versions += n
+	case AuthorStanza:
+		a.Stanzas += n
+	case AuthorComment:
+		a.Comments += n
+	case AuthorRelationReceived:
+		a.RelationsReceived += n
+	}
+}
+
+func (a *AuthorCounts) AddCounts(other AuthorCounts) {
+	a.Versions += other.Versions
+	a.Stanzas += other.Stanzas
+	a.Comments += other.Comments
+	a.RelationsReceived += other.RelationsReceived
+}
+
+func (a AuthorCounts) collapse(ripple matter.Ripple, incr AuthorCounts) {
+	ripple.Set("versions", a.Versions)
+	ripple.Set("stanzas", a.Stanzas)
+	ripple.Set("comments", a.Comments)
+	ripple.Set("relations_received", a.RelationsReceived)
+
+	ripple.DoIncr("versions", incr.Versions)
+	ripple.DoIncr("stanzas", incr.Stanzas)
+	ripple.DoIncr("comments", incr.Comments)
+	ripple.DoIncr("relations_received", incr.RelationsReceived)
+}
+
+func (a *AuthorCounts) decohere(ripple matter.Ripple) {
+	if count, ok := ripple.Get("versions"); ok {
+		a.Versions = count.(int)
+	}
+	if count, ok := ripple.Get("stanzas"); ok {
+		a.Stanzas = count.(int)
+	}
+	if count, ok := ripple.Get("comments"); ok {
+		a.Comments = count.(int)
+	}
+	if count, ok := ripple.Get("relations_received"); ok {
+		a.RelationsReceived = count.(int)
+	}
+}
+
+type AuthorWeights struct {
+	Version  float64 `json:"version"`
+	Stanza   float64 `json:"stanza"`
+	Comment  float64 `json:"comment"`
+	Relation float64 `json:"relation"`
+}
+
+var DefaultAuthorWeights = AuthorWeights{Version: 3.0, Stanza: 1.0, Comment: 1.0, Relation: 2.0}
+
+func (a AuthorCounts) Weighted(weights AuthorWeights) float64 {
+	return weights.Version*float64(a.Versions) + weights.Stanza*float64(a.Stanzas) + weights.Comment*float64(a.Comments) + weights.Relation*float64(a.RelationsReceived)
+}
+
+// AuthorStat holds the lifetime contributions of an author.
+type AuthorStat struct {
+	km.BaseModel `bson:",inline" kosmos:"author_stat"`
+	XMLName      xml.Name `xml:"author_stat" json:"-" bson:"-"`
+	Name         string   `xml:"name" json:"name" bson:"name"`
+	AuthorCounts `bson:",inline"`
+	incr         AuthorCounts
+}
+
+func (a AuthorStat) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		km.Fld("Name").Eq(a.Name),
+	)
+}
+
+func (a *AuthorStat) Incr(kind AuthorContribution, n int) {
+	a.incr.Add(kind, n)
+	a.AuthorCounts.Add(kind, n)
+}
+
+func (a *AuthorStat) Collapse() matter.Ripple {
+	ripple := a.BaseModel.Collapse()
+	ripple.Set("name", a.Name)
+	a.AuthorCounts.collapse(ripple, a.incr)
+	a.AuthorCounts = AuthorCounts{}
+	return ripple
+}
+
+func (a *AuthorStat) Decohere(ripple matter.Ripple) error {
+	a.AuthorCounts.decohere(ripple)
+	a.incr = AuthorCounts{}
+	return a.BaseModel.Decohere(ripple)
+}
+
+// AuthorActivity holds the contributions of an author during one UTC day and
+// backs the time windowed leaderboard.
+type AuthorActivity struct {
+	km.BaseModel `bson:",inline" kosmos:"author_activity"`
+	Name         string    `bson:"name"`
+	Day          time.Time `bson:"day"`
+	AuthorCounts `bson:",inline"`
+	incr         AuthorCounts
+}
+
+func (a AuthorActivity) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		km.Fld("Name").Eq(a.Name),
+		km.Fld("Day").Eq(a.Day),
+	)
+}
+
+func (a *AuthorActivity) Incr(kind AuthorContribution, n int) {
+	a.incr.Add(kind, n)
+	a.AuthorCounts.Add(kind, n)
+}
+
+func (a *AuthorActivity) Collapse() matter.Ripple {
+	ripple := a.BaseModel.Collapse()
+	ripple.Set("name", a.Name)
+	ripple.Set("day", a.Day)
+	a.AuthorCounts.collapse(ripple, a.incr)
+	a.AuthorCounts = AuthorCounts{}
+	return ripple
+}
+
+func (a *AuthorActivity) Decohere(ripple matter.Ripple) error {
+	a.AuthorCounts.decohere(ripple)
+	a.incr = AuthorCounts{}
+	return a.BaseModel.Decohere(ripple)
+}
+
+// RecordAuthorContribution adds n contributions of kind to the lifetime stat
+// and today's activity of author.
+func RecordAuthorContribution(ctx context.Context, author string, kind AuthorContribution, n int) error {
+	if author == "" || n <= 0 {
+		return nil
+	}
+
+	stat := AuthorStat{Name: author}
+	stat.Incr(kind, n)
+	if err := km.Record(ctx, &stat); err != nil {
+		return err
+	}
+
+	activity := AuthorActivity{Name: author, Day: time.Now().UTC().Truncate(24 * time.Hour)}
+	activity.Incr(kind, n)
+	return km.Record(ctx, &activity)
+}
+
+// newStanzaCount is the number of stanzas of page that were not part of its
+// previous version.
+func newStanzaCount(ctx context.Context, page sitepages.Page) (int, error) {
+	if page.PreviousVersion.IsZero() {
+		return len(page.Contents), nil
+	}
+
+	previous, err := km.Detect[sitepages.Page](
+		km.Fld("ID").Eq(page.PreviousVersion),
+	).Limit(1).PullAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(previous) == 0 {
+		return len(page.Contents), nil
+	}
+
+	count := 0
+	for _, content := range page.Contents {
+		if !slices.Contains(previous[0].Contents, content) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func tallyAuthorActivity(activities []AuthorActivity, weights AuthorWeights) []AuthorStat {
+	byName := make(map[string]*AuthorStat)
+	for _, activity := range activities {
+		stat, ok := byName[activity.Name]
+		if !ok {
+			stat = &AuthorStat{Name: activity.Name}
+			byName[activity.Name] = stat
+		}
+		stat.AuthorCounts.AddCounts(activity.AuthorCounts)
+	}
+
+	retval := make([]AuthorStat, 0, len(byName))
+	for _, stat := range byName {
+		retval = append(retval, *stat)
+	}
+
+	slices.SortFunc(retval, func(a, b AuthorStat) int {
+		if c := cmp.Compare(b.Weighted(weights), a.Weighted(weights)); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return retval
+}
+
+// AuthorLeaderboard ranks authors by their weighted contributions since the
+// given time. The daily activities are summed per author by the database.
+func AuthorLeaderboard(ctx context.Context, since time.Time, weights AuthorWeights) ([]AuthorStat, error) {
+	activities, err := km.ProjectInto[AuthorActivity](
+		km.Fld("name").With().GroupKey("name"),
+		km.Fld("versions").With().One(),
+		km.Fld("stanzas").With().One(),
+		km.Fld("comments").With().One(),
+		km.Fld("relations_received").With().One(),
+	).From(
+		km.Detect[AuthorActivity](
+			km.Fld("Day").Gte(since.UTC().Truncate(24*time.Hour)),
+		).GroupBy(
+			"name",
+		).Accumulate(
+			km.Fld("versions").With().Sum("versions"),
+			km.Fld("stanzas").With().Sum("stanzas"),
+			km.Fld("comments").With().Sum("comments"),
+			km.Fld("relations_received").With().Sum("relations_received"),
+		),
+	).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return tallyAuthorActivity(activities, weights), nil
+}
+
+// PullLeaderboard returns the top contributors since the "since" query
+// parameter (absolute or relative, default the last 7 days).
+func PullLeaderboard(limit int) HandlerFunc[AuthorStat] {
+	return func(s *Session[AuthorStat]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Leaderboard Session missing Response structure")
+		}
+
+		now := time.Now().UTC()
+		since := now.AddDate(0, 0, -7)
+		if sinceStr := s.URLQuery().Get("since"); sinceStr != "" {
+			var err error
+			since, err = ParseTimeBound(sinceStr, now)
+			if err != nil {
+				return NewStatusError(err, http.StatusBadRequest)
+			}
+		}
+
+		if now.Sub(since) > MAX_TIME_WINDOW {
+			return NewStatusString("time window too large", http.StatusBadRequest)
+		}
+
+		leaders, err := AuthorLeaderboard(s.Request.Context(), since, DefaultAuthorWeights)
+		if err != nil {
+			return fmt.Errorf("AuthorLeaderboard request error: %v", err)
+		}
+
+		if len(leaders) > limit {
+			leaders = leaders[:limit]
+		}
+
+		for _, leader := range leaders {
+			s.Response.Append(leader)
+		}
+		return nil
+	}
+}
+
+// ByAuthorFromPath selects the AuthorStat of the author named in the path.
+func ByAuthorFromPath(pathName string) Filter {
+	return Fld("Name").ByPathParam(pathName)
+}
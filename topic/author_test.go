@@ -0,0 +1,30 @@
+package topic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTallyAuthorActivity(t *testing.T) {
+	day := time.Date(2024, 3, 10, 0, 0, 0, 0, time.UTC)
+	activities := []AuthorActivity{
+		{Name: "ada", Day: day, AuthorCounts: AuthorCounts{Versions: 1, Stanzas: 4}},
+		{Name: "bob", Day: day, AuthorCounts: AuthorCounts{Comments: 3}},
+		{Name: "ada", Day: day.AddDate(0, 0, 1), AuthorCounts: AuthorCounts{Comments: 1, RelationsReceived: 2}},
+		{Name: "cy", Day: day, AuthorCounts: AuthorCounts{Comments: 3}},
+	}
+
+	leaders := tallyAuthorActivity(activities, DefaultAuthorWeights)
+	if len(leaders) != 3 {
+		t.Fatalf("expected 3 authors, got %d", len(leaders))
+	}
+
+	ada := leaders[0]
+	if ada.Name != "ada" || ada.Versions != 1 || ada.Stanzas != 4 || ada.Comments != 1 || ada.RelationsReceived != 2 {
+		t.Errorf("expected ada's days to be summed, got %+v", ada)
+	}
+
+	if leaders[1].Name != "bob" || leaders[2].Name != "cy" {
+		t.Errorf("expected ties ordered by name, got %s %s", leaders[1].Name, leaders[2].Name)
+	}
+}
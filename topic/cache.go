@@ -0,0 +1,266 @@
+package topic
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Feed results are cached under keys that embed the generation of every tag
+// they depend on. Invalidating a tag bumps its generation, so stale entries
+// are never read again and simply expire with their TTL. Only the counters
+// need to be shared for invalidation to reach every server. Pages and comments
+// invalidate their root and the feed as they are recorded, and moderation as
+// it changes the comment count of a root.
+
+var FEED_CACHE_TTL = 30 * time.Second
+var MAX_MEMORY_CACHE_ENTRIES = 10000
+var MAX_MEMORY_CACHE_COUNTERS = 10000
+
+// MEMORY_COUNTER_TTL is how long a generation counter lives once neither read
+// nor incremented. It must exceed the TTL of the entries: a counter that
+// expires restarts at zero, which is only safe once every entry built on its
+// previous values has expired.
+var MEMORY_COUNTER_TTL = time.Hour
+
+const cacheFeedTag = "feed"
+
+func cacheRootTag(root bson.ObjectID) string {
+	return "root:" + root.Hex()
+}
+
+// CacheBackend stores encoded results. Implementations must be safe for
+// concurrent use; a zero ttl means no expiry. Counters created by Incr may
+// expire once unused for longer than any entry TTL.
+type CacheBackend interface {
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	Incr(ctx context.Context, key string) (int64, error)
+}
+
+type Cache struct {
+	Backend CacheBackend
+	TTL     time.Duration
+}
+
+func NewCache(backend CacheBackend, ttl time.Duration) *Cache {
+	return &Cache{Backend: backend, TTL: ttl}
+}
+
+// FeedCache caches feed and ranking results. Caching is disabled while it is nil.
+var FeedCache *Cache
+
+func (c *Cache) generation(ctx context.Context, tag string) (string, error) {
+	value, ok, err := c.Backend.Get(ctx, "gen:"+tag)
+	if err != nil || !ok {
+		return "0", err
+	}
+	return string(value), nil
+}
+
+func (c *Cache) key(ctx context.Context, name string, tags []string) (string, error) {
+	key := name
+	for _, tag := range tags {
+		gen, err := c.generation(ctx, tag)
+		if err != nil {
+			return "", err
+		}
+		key += "|" + tag + "@" + gen
+	}
+	return key, nil
+}
+
+// Invalidate drops every cached result depending on any of the tags.
+func (c *Cache) Invalidate(ctx context.Context, tags ...string) error {
+	for _, tag := range tags {
+		if _, err := c.Backend.Incr(ctx, "gen:"+tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cached returns the result stored under name and tags, or loads and stores
+// it. Cache failures fall back to load: the cache never fails a request.
+func cached[T any](ctx context.Context, c *Cache, name string, tags []string, load func() (T, error)) (T, error) {
+	if c == nil {
+		return load()
+	}
+
+	key, err := c.key(ctx, name, tags)
+	if err != nil {
+		log.Printf("Cache: failed to read generations of %s: %v", name, err)
+		return load()
+	}
+
+	if data, ok, err := c.Backend.Get(ctx, key); err == nil && ok {
+		var entry struct {
+			Value T `bson:"value"`
+		}
+		if err := bson.Unmarshal(data, &entry); err == nil {
+			return entry.Value, nil
+		}
+	}
+
+	value, err := load()
+	if err != nil {
+		return value, err
+	}
+
+	data, err := bson.Marshal(struct {
+		Value T `bson:"value"`
+	}{value})
+	if err == nil {
+		err = c.Backend.Set(ctx, key, data, c.TTL)
+	}
+	if err != nil {
+		log.Printf("Cache: failed to store %s: %v", name, err)
+	}
+	return value, nil
+}
+
+// invalidateFeed is called from the Decohere hooks; it only logs on failure.
+func invalidateFeed(ctx context.Context, tags ...string) {
+	if FeedCache == nil {
+		return
+	}
+	if err := FeedCache.Invalidate(ctx, tags...); err != nil {
+		log.Printf("Cache: failed to invalidate %v: %v", tags, err)
+	}
+}
+
+// scorerCacheKey identifies a scorer by its configuration so that reloaded
+// scorers never read results ranked by their previous weights.
+func scorerCacheKey(scorer Scorer) string {
+	sum := sha1.Sum(fmt.Appendf(nil, "%T%+v", scorer, scorer))
+	return hex.EncodeToString(sum[:8])
+}
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+type memoryCounter struct {
+	value    int64
+	lastUsed time.Time
+}
+
+// MemoryCache is an in-process CacheBackend. Entries and counters are held
+// in separate maps, each bounded: at capacity expired entries are dropped,
+// and every entry when that frees less than a quarter of the map; the least
+// recently used counter is dropped.
+type MemoryCache struct {
+	mutex    sync.Mutex
+	entries  map[string]memoryEntry
+	counters map[string]memoryCounter
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{
+		entries:  make(map[string]memoryEntry),
+		counters: make(map[string]memoryCounter),
+	}
+}
+
+func (m *MemoryCache) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if counter, ok := m.counters[key]; ok {
+		if now.Sub(counter.lastUsed) > MEMORY_COUNTER_TTL {
+			delete(m.counters, key)
+			return nil, false, nil
+		}
+		counter.lastUsed = now
+		m.counters[key] = counter
+		return []byte(strconv.FormatInt(counter.value, 10)), true, nil
+	}
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+
+	if !entry.expires.IsZero() && now.After(entry.expires) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+func (m *MemoryCache) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	if len(m.entries) >= MAX_MEMORY_CACHE_ENTRIES {
+		m.sweep(now)
+	}
+
+	entry := memoryEntry{value: value}
+	if ttl > 0 {
+		entry.expires = now.Add(ttl)
+	}
+	m.entries[key] = entry
+	return nil
+}
+
+func (m *MemoryCache) Incr(ctx context.Context, key string) (int64, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	now := time.Now()
+	counter, ok := m.counters[key]
+	if ok && now.Sub(counter.lastUsed) > MEMORY_COUNTER_TTL {
+		counter = memoryCounter{}
+	}
+
+	if !ok && len(m.counters) >= MAX_MEMORY_CACHE_COUNTERS {
+		m.evictCounter(now)
+	}
+
+	counter.value++
+	counter.lastUsed = now
+	m.counters[key] = counter
+	return counter.value, nil
+}
+
+// sweep drops expired entries, or every entry when too few have expired for
+// the next sweeps to be far apart.
+func (m *MemoryCache) sweep(now time.Time) {
+	for key, entry := range m.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(m.entries, key)
+		}
+	}
+
+	if len(m.entries) > MAX_MEMORY_CACHE_ENTRIES*3/4 {
+		clear(m.entries)
+	}
+}
+
+// evictCounter drops the least recently used counter. A counter still in use
+// restarts at zero, so the entries are dropped with it unless it had expired.
+func (m *MemoryCache) evictCounter(now time.Time) {
+	var oldest string
+	var oldestUsed time.Time
+	for key, counter := range m.counters {
+		if oldest == "" || counter.lastUsed.Before(oldestUsed) {
+			oldest, oldestUsed = key, counter.lastUsed
+		}
+	}
+
+	delete(m.counters, oldest)
+	if now.Sub(oldestUsed) <= MEMORY_COUNTER_TTL {
+		clear(m.entries)
+	}
+}
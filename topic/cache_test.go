@@ -0,0 +1,130 @@
+package topic
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestCacheInvalidation(t *testing.T) {
+	ctx := context.Background()
+	c := NewCache(NewMemoryCache(), time.Minute)
+	root := bson.NewObjectID()
+
+	loads := 0
+	load := func() ([]PageStat, error) {
+		loads++
+		stat := PageStat{Title: "title", CommentCount: loads}
+		stat.ID = root
+		return []PageStat{stat}, nil
+	}
+
+	for range 2 {
+		stats, err := cached(ctx, c, "fresh", []string{cacheFeedTag}, load)
+		if err != nil || len(stats) != 1 || stats[0].ID != root || stats[0].CommentCount != 1 {
+			t.Fatalf("unexpected cached result %+v %v", stats, err)
+		}
+	}
+	if loads != 1 {
+		t.Errorf("expected 1 load before invalidation, got %d", loads)
+	}
+
+	if err := c.Invalidate(ctx, cacheRootTag(root)); err != nil {
+		t.Fatal(err)
+	}
+	cached(ctx, c, "fresh", []string{cacheFeedTag}, load)
+	if loads != 1 {
+		t.Errorf("unrelated tag should not invalidate, got %d loads", loads)
+	}
+
+	if err := c.Invalidate(ctx, cacheFeedTag); err != nil {
+		t.Fatal(err)
+	}
+	stats, _ := cached(ctx, c, "fresh", []string{cacheFeedTag}, load)
+	if loads != 2 || stats[0].CommentCount != 2 {
+		t.Errorf("expected reload after invalidation, got %d loads %+v", loads, stats)
+	}
+}
+
+func TestMemoryCacheExpiry(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemoryCache()
+	m.Set(ctx, "short", []byte("a"), time.Nanosecond)
+	m.Set(ctx, "long", []byte("b"), time.Hour)
+	time.Sleep(time.Millisecond)
+
+	if _, ok, _ := m.Get(ctx, "short"); ok {
+		t.Errorf("expected expired entry to be dropped")
+	}
+	if value, ok, _ := m.Get(ctx, "long"); !ok || string(value) != "b" {
+		t.Errorf("expected live entry, got %q %v", value, ok)
+	}
+}
+
+func TestMemoryCacheCounters(t *testing.T) {
+	ctx := context.Background()
+	limit := MAX_MEMORY_CACHE_COUNTERS
+	MAX_MEMORY_CACHE_COUNTERS = 2
+	t.Cleanup(func() { MAX_MEMORY_CACHE_COUNTERS = limit })
+
+	m := NewMemoryCache()
+	m.Set(ctx, "entry", []byte("a"), time.Hour)
+	m.Incr(ctx, "gen:a")
+	m.Incr(ctx, "gen:a")
+	m.Incr(ctx, "gen:b")
+	if len(m.entries) != 1 {
+		t.Errorf("expected counters to leave entries alone, got %d entries", len(m.entries))
+	}
+
+	if value, ok, _ := m.Get(ctx, "gen:a"); !ok || string(value) != "2" {
+		t.Errorf("expected counter value 2, got %q %v", value, ok)
+	}
+
+	m.Incr(ctx, "gen:c")
+	if len(m.counters) != 2 {
+		t.Errorf("expected counters bounded to 2, got %d", len(m.counters))
+	}
+	if _, ok, _ := m.Get(ctx, "gen:b"); ok {
+		t.Errorf("expected least recently used counter to be evicted")
+	}
+	if len(m.entries) != 0 {
+		t.Errorf("expected entries dropped with a live counter, got %d", len(m.entries))
+	}
+}
+
+func TestCommentInvalidatesFeed(t *testing.T) {
+	ctx := context.Background()
+	previous := FeedCache
+	FeedCache = NewCache(NewMemoryCache(), time.Minute)
+	t.Cleanup(func() { FeedCache = previous })
+
+	root := bson.NewObjectID()
+	loads := 0
+	load := func() ([]PageStat, error) {
+		loads++
+		return []PageStat{{CommentCount: loads}}, nil
+	}
+
+	for range 2 {
+		cached(ctx, FeedCache, "fresh", []string{cacheFeedTag}, load)
+		cached(ctx, FeedCache, "top", []string{cacheRootTag(root)}, load)
+	}
+	if loads != 2 {
+		t.Fatalf("expected both results cached, got %d loads", loads)
+	}
+
+	// held for moderation, so recording it writes nothing else
+	comment := Comment{Root: root, State: CommentPending}
+	comment.ID = bson.NewObjectID()
+	if err := comment.Decohere(comment.BaseModel.Collapse()); err != nil {
+		t.Fatal(err)
+	}
+
+	cached(ctx, FeedCache, "fresh", []string{cacheFeedTag}, load)
+	cached(ctx, FeedCache, "top", []string{cacheRootTag(root)}, load)
+	if loads != 4 {
+		t.Errorf("expected a recorded comment to invalidate the feed and its root, got %d loads", loads)
+	}
+}
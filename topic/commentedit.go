@@ -0,0 +1,295 @@
+package topic
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"git.mypierian.com/borghives/entanglement"
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// COMMENT_EDIT_WINDOW is how long after its moment a comment may be edited.
+var COMMENT_EDIT_WINDOW = 15 * time.Minute
+
+var MAX_COMMENT_REVISIONS int64 = 100
+
+// CommentRevision is a superseded version of a comment. As the body of an
+// edit it carries the new content instead, under the ID correlated for the edit.
+// A stored revision has an ID of its own and keeps the correlated ID as Nonce.
+type CommentRevision struct {
+	km.BaseModel `bson:",inline" kosmos:"comment_revision"`
+	Nonce        bson.ObjectID `json:"-" bson:"nonce"`
+	CommentId    bson.ObjectID `json:"commentid" bson:"comment_id"`
+	Root         bson.ObjectID `json:"root" bson:"root"`
+	UserName     string        `json:"username,omitempty" bson:"user_name,omitempty"`
+	Moment       string        `json:"moment" bson:"moment"`
+	Content      string        `json:"content" bson:"content"`
+	EventAt      time.Time     `json:"eventat" bson:"event_at"`
+	SupersededAt time.Time     `json:"supersededat" bson:"superseded_at"`
+}
+
+// commentEdit records only the edited fields of a comment.
+type commentEdit struct {
+	km.BaseModel `bson:",inline" kosmos:"comment"`
+	Content      string    `bson:"content"`
+	EditedAt     time.Time `bson:"edited_at"`
+}
+
+// commentEditNonce claims the ID correlated for an edit. Every claim increments
+// Uses atomically and reads it back, so only the first edit with it succeeds.
+type commentEditNonce struct {
+	km.BaseModel `bson:",inline" kosmos:"comment_edit_nonce"`
+	Uses         int `bson:"uses"`
+}
+
+func (n *commentEditNonce) Collapse() matter.Ripple {
+	ripple := n.BaseModel.Collapse()
+	ripple.DoIncr("uses", 1)
+	return ripple
+}
+
+func (n *commentEditNonce) Decohere(ripple matter.Ripple) error {
+	if uses, ok := ripple.Get("uses"); ok {
+		n.Uses = uses.(int)
+	}
+	return n.BaseModel.Decohere(ripple)
+}
+
+// claimEditNonce rejects an edit ID that was already used, by a claim or by a
+// revision recorded under it.
+func claimEditNonce(ctx context.Context, nonce bson.ObjectID) error {
+	used, err := km.Detect[CommentRevision](
+		km.Or(km.Fld("ID").Eq(nonce), km.Fld("nonce").Eq(nonce)),
+	).Limit(1).PullAll(ctx)
+	if err != nil {
+		return fmt.Errorf("Comment Edit nonce request error: %v", err)
+	}
+
+	if len(used) > 0 {
+		return NewStatusString("comment edit already applied", http.StatusConflict)
+	}
+
+	claim := commentEditNonce{}
+	claim.ID = nonce
+	if err := km.Record(ctx, &claim); err != nil {
+		return fmt.Errorf("Comment Edit nonce record error: %v", err)
+	}
+
+	if claim.Uses != 1 {
+		return NewStatusString("comment edit already applied", http.StatusConflict)
+	}
+	return nil
+}
+
+func (r CommentRevision) GetRootID() bson.ObjectID {
+	return r.Root
+}
+
+func (r CommentRevision) TransitionStates(frame entanglement.Session) entanglement.TypeStateCorrelation {
+	correlation := make(entanglement.TypeStateCorrelation)
+	return correlation
+}
+
+// CheckTransition verifies that the edit ID was correlated for the comment,
+// root and moment of the edit, as Comment.CheckTransition does for creation.
+func (r CommentRevision) CheckTransition(frame entanglement.Session) error {
+	now := time.Now().UTC()
+	tmoment, err := sitepages.ParseMomentString(r.Moment)
+	if err != nil {
+		return fmt.Errorf("Check Comment Edit Transition: %v", err)
+	}
+
+	age := now.Sub(tmoment.UTC())
+	if age.Minutes() > 30 {
+		return fmt.Errorf("Check Comment Edit Transition: stale moment %v", r.Moment)
+	}
+	frame = frame.CreateSubFrame("comment_edit_system")
+	frame.EntangleProperty("commentid", r.CommentId.Hex())
+	frame.EntangleProperty("rootid", r.Root.Hex())
+	frame.EntangleProperty("moment", r.Moment)
+	derivedHexID := frame.GenerateCorrelation("--comment-editor")
+	if derivedHexID != r.ID.Hex() {
+		slog.Debug("Check Comment Edit Transition: mismatch revision id",
+			slog.String("ID", r.ID.Hex()),
+			slog.String("ExpectedID", derivedHexID),
+			slog.String("FrameState", frame.StateString()),
+		)
+		return NewStatusString("Failed ID Expectation", http.StatusExpectationFailed)
+	}
+	return nil
+}
+
+func EntangleCommentEditProperties(frame entanglement.Session, commentId bson.ObjectID, rootId bson.ObjectID) entanglement.TypeStateCorrelation {
+	correlation := make(entanglement.TypeStateCorrelation)
+	moment := sitepages.GenerateMomentString(0)
+
+	frame = frame.CreateSubFrame("comment_edit_system")
+	frame.EntangleProperty("commentid", commentId.Hex())
+	frame.EntangleProperty("rootid", rootId.Hex())
+	frame.EntangleProperty("moment", moment)
+	nextId := frame.GenerateCorrelation("--comment-editor")
+	correlation.AddCorrelation("commentedit", "--comment-editor", nextId)
+	correlation.AddCorrelation("commentedit", "moment", moment)
+	return correlation
+}
+
+// CanEdit reports whether the comment may still be edited by its author.
+func (c Comment) CanEdit(userName string, now time.Time) error {
+	if userName == "" || userName != c.UserName {
+		return NewStatusString("Unauthorized to edit comment", http.StatusForbidden)
+	}
+
+	if c.CommentState() == CommentRemoved {
+		return NewStatusString("comment removed", http.StatusConflict)
+	}
+
+	tmoment, err := sitepages.ParseMomentString(c.Moment)
+	if err != nil {
+		return fmt.Errorf("Comment edit: %v", err)
+	}
+
+	if now.Sub(tmoment.UTC()) > COMMENT_EDIT_WINDOW {
+		return NewStatusString("comment edit window has passed", http.StatusConflict)
+	}
+	return nil
+}
+
+// EditComment replaces the content of the comment in the body for its author
+// within COMMENT_EDIT_WINDOW, keeping the previous content as a revision. The
+// body must pass CheckInBodyCorrelation first.
+func EditComment() HandlerFunc[CommentRevision] {
+	return func(s *Session[CommentRevision]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Comment Edit Session missing Response structure")
+		}
+
+		session, err := s.RequireUser()
+		if err != nil {
+			return err
+		}
+
+		content := strings.TrimSpace(s.InBody.Content)
+		if content == "" {
+			return NewStatusString("missing comment content", http.StatusBadRequest)
+		}
+
+		ctx := s.Request.Context()
+		comments, err := km.Detect[Comment](km.Fld("ID").Eq(s.InBody.CommentId)).Limit(1).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("Comment Edit request error: %v", err)
+		}
+
+		if len(comments) == 0 || comments[0].Root != s.InBody.Root {
+			return NewStatusString("comment not found", http.StatusNotFound)
+		}
+
+		comment := comments[0]
+		now := time.Now().UTC()
+		if err := comment.CanEdit(session.UserName, now); err != nil {
+			return err
+		}
+
+		if content == comment.Content {
+			s.Response.SetTargetID(comment.ID)
+			s.Response.Append(comment)
+			return nil
+		}
+
+		if err := claimEditNonce(ctx, s.InBody.ID); err != nil {
+			return err
+		}
+
+		previous := CommentRevision{
+			Nonce:        s.InBody.ID,
+			CommentId:    comment.ID,
+			Root:         comment.Root,
+			UserName:     comment.UserName,
+			Moment:       comment.Moment,
+			Content:      comment.Content,
+			EventAt:      comment.EventAt,
+			SupersededAt: now,
+		}
+		if !comment.EditedAt.IsZero() {
+			previous.EventAt = comment.EditedAt
+		}
+		previous.ID = bson.NewObjectID()
+		if err := km.Record(ctx, &previous); err != nil {
+			return fmt.Errorf("Comment Edit revision record error: %v", err)
+		}
+
+		update := commentEdit{Content: content, EditedAt: now}
+		update.ID = comment.ID
+		if err := km.Record(ctx, &update); err != nil {
+			return fmt.Errorf("Comment Edit record error: %v", err)
+		}
+
+		comment.Content = content
+		comment.EditedAt = now
+
+		if err := NotifyComment(ctx, comment); err != nil {
+			slog.Error("Comment Edit: failed to notify", slog.Any("error", err))
+		}
+
+		s.Response.SetTargetID(comment.ID)
+		s.Response.Append(comment)
+		return nil
+	}
+}
+
+// PullCommentHistory returns to moderators the comment of the idPath path
+// value with its previous versions, oldest first.
+func PullCommentHistory(idPath string) HandlerFunc[CommentRevision] {
+	return func(s *Session[CommentRevision]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Comment History Session missing Response structure")
+		}
+
+		if _, err := s.RequireModerator(); err != nil {
+			return err
+		}
+
+		commentId, err := bson.ObjectIDFromHex(s.Request.PathValue(idPath))
+		if err != nil {
+			return NewStatusString("invalid id from path", http.StatusBadRequest)
+		}
+
+		ctx := s.Request.Context()
+		comments, err := km.Detect[Comment](km.Fld("ID").Eq(commentId)).Limit(1).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("Comment History request error: %v", err)
+		}
+
+		if len(comments) == 0 {
+			return NewStatusString("comment not found", http.StatusNotFound)
+		}
+
+		revisions, err := km.Detect[CommentRevision](
+			km.Fld("CommentId").Eq(commentId),
+		).Limit(MAX_COMMENT_REVISIONS).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("Comment History revision request error: %v", err)
+		}
+		sortRevisions(revisions)
+
+		s.Response.SetTargetID(commentId)
+		s.Response.Append(comments[0])
+		for _, revision := range revisions {
+			s.Response.Append(revision)
+		}
+		return nil
+	}
+}
+
+func sortRevisions(revisions []CommentRevision) {
+	slices.SortFunc(revisions, func(a, b CommentRevision) int {
+		return a.EventAt.Compare(b.EventAt)
+	})
+}
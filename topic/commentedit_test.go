@@ -0,0 +1,26 @@
+package topic
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCommentCanEdit(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	comment := Comment{UserName: "ada", Moment: now.Add(-5 * time.Minute).Format("2006-01-02 15:04")}
+
+	if err := comment.CanEdit("ada", now); err != nil {
+		t.Errorf("expected the author to edit within the window: %v", err)
+	}
+	if err := comment.CanEdit("bob", now); err == nil {
+		t.Errorf("expected another user not to edit")
+	}
+	if err := comment.CanEdit("ada", now.Add(COMMENT_EDIT_WINDOW)); err == nil {
+		t.Errorf("expected the edit window to close")
+	}
+
+	comment.State = CommentRemoved
+	if err := comment.CanEdit("ada", now); err == nil {
+		t.Errorf("expected a removed comment not to be edited")
+	}
+}
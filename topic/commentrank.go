@@ -0,0 +1,230 @@
+package topic
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"slices"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// z-score of the confidence used for the Wilson lower bound (95%)
+var WILSON_Z = 1.96
+
+const (
+	CommentUpvote   = "upvote"
+	CommentDownvote = "downvote"
+)
+
+const (
+	CommentOrderBest          = "best"
+	CommentOrderNew           = "new"
+	CommentOrderControversial = "controversial"
+)
+
+// WilsonLowerBound is the lower bound of the confidence interval of the
+// fraction of up votes. It favours comments with many votes over comments
+// with a lucky few.
+func WilsonLowerBound(up int, down int) float64 {
+	n := float64(up + down)
+	if n == 0 {
+		return 0
+	}
+
+	p := float64(up) / n
+	z := WILSON_Z
+	return (p + z*z/(2*n) - z*math.Sqrt((p*(1-p)+z*z/(4*n))/n)) / (1 + z*z/n)
+}
+
+// ControversyScore is high when a comment has many votes split evenly.
+func ControversyScore(up int, down int) float64 {
+	if up <= 0 || down <= 0 {
+		return 0
+	}
+
+	magnitude := float64(up + down)
+	balance := float64(min(up, down)) / float64(max(up, down))
+	return math.Pow(magnitude, balance)
+}
+
+// commentScore records only the ranking fields of a comment, leaving its
+// content and the Decohere side effects of Comment untouched.
+type commentScore struct {
+	km.BaseModel `bson:",inline" kosmos:"comment"`
+	Score        float64   `bson:"score"`
+	Controversy  float64   `bson:"controversy"`
+	BestBy       time.Time `bson:"best_by"`
+}
+
+// freshnessHorizon is how long a scorer keeps activity fresh: its window, else
+// its half-life, else COMMENT_BEST_HORIZON.
+func freshnessHorizon(scorer Scorer) time.Duration {
+	if weighted, ok := scorer.(WeightedScorer); ok {
+		if weighted.WindowHours > 0 {
+			return weighted.Window()
+		}
+		if weighted.HalfLifeHours > 0 {
+			return time.Duration(weighted.HalfLifeHours * float64(time.Hour))
+		}
+	}
+	return COMMENT_BEST_HORIZON
+}
+
+// COMMENT_BEST_HORIZON is the freshness horizon of the best order when the
+// default feed scorer has neither a window nor a half-life.
+var COMMENT_BEST_HORIZON = 24 * time.Hour
+
+// commentBestBy is the key of the best order: the moment the comment was
+// posted, pushed later by its score up to the freshness horizon of the default
+// feed. A comment past its horizon ranks after fresh comments however well it
+// scored. It is kept to the millisecond the database stores.
+func commentBestBy(c Comment) time.Time {
+	posted, err := sitepages.ParseMomentString(c.Moment)
+	if err != nil {
+		posted = c.EventAt
+	}
+	boost := time.Duration(c.Score * float64(freshnessHorizon(defaultFeedScorer())))
+	return posted.UTC().Add(boost).Truncate(time.Millisecond)
+}
+
+func scoreComment(c *Comment, counts []RelationCount) {
+	up, down := 0, 0
+	for _, count := range counts {
+		switch count.Relation {
+		case CommentUpvote:
+			up += count.Count
+		case CommentDownvote:
+			down += count.Count
+		}
+	}
+
+	c.Score = WilsonLowerBound(up, down)
+	c.Controversy = ControversyScore(up, down)
+	c.BestBy = commentBestBy(*c)
+}
+
+// RescoreComment recomputes the score of a comment from its votes and
+// persists it with its BestBy. Votes rescore their comment, so reads sort by
+// the stored score.
+func RescoreComment(ctx context.Context, commentId bson.ObjectID) error {
+	comments, err := km.Detect[Comment](km.Fld("ID").Eq(commentId)).Limit(1).PullAll(ctx)
+	if err != nil {
+		return err
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+
+	counts, err := CommentRelationCounts(ctx, []bson.ObjectID{commentId})
+	if err != nil {
+		return err
+	}
+
+	comment := comments[0]
+	scoreComment(&comment, counts[commentId])
+	update := commentScore{Score: comment.Score, Controversy: comment.Controversy, BestBy: comment.BestBy}
+	update.ID = commentId
+	if err := km.Record(ctx, &update); err != nil {
+		return fmt.Errorf("RescoreComment: failed to record score %v", err)
+	}
+	return nil
+}
+
+// commentSort is the database order of a comment sort; ties go to the newest.
+func commentSort(order string) []expression.SortField {
+	switch order {
+	case CommentOrderBest:
+		return append([]expression.SortField{km.Fld("BestBy").Desc()}, newestFirst()...)
+	case CommentOrderControversial:
+		return append([]expression.SortField{km.Fld("Controversy").Desc()}, newestFirst()...)
+	}
+	return newestFirst()
+}
+
+// commentOrderScore is the score a comment is first sorted by in order; for
+// best, its BestBy in unix milliseconds.
+func commentOrderScore(c Comment, order string) float64 {
+	switch order {
+	case CommentOrderBest:
+		return float64(c.BestBy.UnixMilli())
+	case CommentOrderControversial:
+		return c.Controversy
+	}
+	return 0
+}
+
+// compareComments orders two comments in process as commentSort does.
+func compareComments(a Comment, b Comment, order string) int {
+	if c := cmp.Compare(commentOrderScore(b, order), commentOrderScore(a, order)); c != 0 {
+		return c
+	}
+	if c := b.EventAt.Compare(a.EventAt); c != 0 {
+		return c
+	}
+	return bytes.Compare(b.ID[:], a.ID[:])
+}
+
+func SortComments(comments []Comment, order string) {
+	slices.SortFunc(comments, func(a, b Comment) int {
+		return compareComments(a, b, order)
+	})
+}
+
+func CommentOrderFromQuery(s *RequestContext) (string, error) {
+	order := s.URLQuery().Get("sort")
+	switch order {
+	case "":
+		return CommentOrderBest, nil
+	case CommentOrderBest, CommentOrderNew, CommentOrderControversial:
+		return order, nil
+	}
+	return "", NewStatusString("invalid comment sort", http.StatusBadRequest)
+}
+
+// PullRankedComments pulls comments like Pull, sorted in the query by the
+// "sort" query parameter (best, new or controversial) before the limit.
+func PullRankedComments(limit int64) HandlerFunc[Comment] {
+	return func(s *Session[Comment]) error {
+		if s.Detector == nil {
+			return fmt.Errorf("Comment Query Session missing Detector")
+		}
+
+		if s.Response == nil {
+			return fmt.Errorf("Comment Query Session missing Response structure")
+		}
+
+		order, err := CommentOrderFromQuery(&s.RequestContext)
+		if err != nil {
+			return err
+		}
+
+		//clone directive
+		entityDetector := *s.Detector
+		results, err := entityDetector.Sort(commentSort(order)...).Limit(limit).PullAll(s.Request.Context())
+		if err != nil {
+			return fmt.Errorf("Comment PullAll request error: %v", err)
+		}
+
+		userName, moderator := s.Viewer()
+		for _, result := range results {
+			if !IsVisibleResult(&result, userName, moderator) {
+				continue
+			}
+
+			err := SanitizeResult(s, &result)
+			if err != nil {
+				return err
+			}
+			s.Response.Append(result)
+		}
+		return nil
+	}
+}
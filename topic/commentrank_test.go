@@ -0,0 +1,71 @@
+package topic
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCommentScores(t *testing.T) {
+	if WilsonLowerBound(0, 0) != 0 {
+		t.Errorf("expected zero score without votes")
+	}
+
+	few := WilsonLowerBound(2, 0)
+	many := WilsonLowerBound(90, 10)
+	if few >= many {
+		t.Errorf("expected 90/10 (%v) to outrank 2/0 (%v)", many, few)
+	}
+	if math.Abs(many-0.8256) > 1e-3 {
+		t.Errorf("unexpected wilson bound for 90/10: %v", many)
+	}
+
+	if ControversyScore(10, 0) != 0 {
+		t.Errorf("expected unanimous votes not to be controversial")
+	}
+	if ControversyScore(50, 50) <= ControversyScore(90, 10) {
+		t.Errorf("expected an even split to be more controversial")
+	}
+}
+
+func TestSortComments(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	counts := map[string][]RelationCount{
+		"old-good":  {{Relation: CommentUpvote, Count: 40}, {Relation: CommentDownvote, Count: 2}},
+		"new-split": {{Relation: CommentUpvote, Count: 20}, {Relation: CommentDownvote, Count: 20}},
+		"newest":    nil,
+	}
+
+	counts["stale-good"] = counts["old-good"]
+
+	var comments []Comment
+	for i, name := range []string{"stale-good", "old-good", "new-split", "newest"} {
+		posted := now.Add(time.Duration(i) * time.Hour)
+		if name == "stale-good" {
+			posted = now.Add(-3 * 24 * time.Hour)
+		}
+		c := Comment{Content: name, Moment: posted.Format("2006-01-02 15:04"), EventAt: posted}
+		scoreComment(&c, counts[name])
+		comments = append(comments, c)
+	}
+
+	if !comments[3].BestBy.Equal(now.Add(3 * time.Hour)) {
+		t.Errorf("expected an unvoted comment to be best by its moment, got %v", comments[3].BestBy)
+	}
+
+	// past the horizon of the default feed a good score no longer outranks
+	expected := map[string][]string{
+		CommentOrderBest:          {"old-good", "new-split", "newest", "stale-good"},
+		CommentOrderNew:           {"newest", "new-split", "old-good", "stale-good"},
+		CommentOrderControversial: {"new-split", "old-good", "stale-good", "newest"},
+	}
+
+	for order, names := range expected {
+		SortComments(comments, order)
+		for i, name := range names {
+			if comments[i].Content != name {
+				t.Errorf("%s: expected %s at %d, got %s", order, name, i, comments[i].Content)
+			}
+		}
+	}
+}
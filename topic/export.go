@@ -0,0 +1,256 @@
+package topic
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_EXPORT_ITEMS int64 = 100000
+
+// UserExport is everything attributed to a user, as answered to a data access
+// request.
+type UserExport struct {
+	UserId       bson.ObjectID       `json:"userid"`
+	UserName     string              `json:"username"`
+	GeneratedAt  time.Time           `json:"generatedat"`
+	Pages        []sitepages.Page    `json:"pages"`
+	Comments     []sitepages.Comment `json:"comments"`
+	Revisions    []CommentRevision   `json:"revisions"`
+	Notified     []Notification      `json:"notifications"`
+	PageLinks    []ExportedLink      `json:"pagelinks"`
+	CommentLinks []ExportedLink      `json:"commentlinks"`
+	RootLinks    []ExportedLink      `json:"rootlinks"`
+	AuthorLinks  []ExportedLink      `json:"authorlinks"`
+	Lists        []ReadingList       `json:"lists"`
+	ListItems    []ReadingListItem   `json:"listitems"`
+}
+
+// ExportedLink is a relation as exported. LinkDescription leaves the subject,
+// the creator and the list name out of API responses; the export keeps them.
+type ExportedLink struct {
+	SubjectId bson.ObjectID `json:"subjid"`
+	ObjectId  bson.ObjectID `json:"objid"`
+	Relation  string        `json:"relation"`
+	CreatorId bson.ObjectID `json:"creatorid,omitzero"`
+	Name      string        `json:"name,omitempty"`
+	State     string        `json:"state,omitempty"`
+}
+
+func exportLink(link LinkDescription) ExportedLink {
+	return ExportedLink{
+		SubjectId: link.SubjectId,
+		ObjectId:  link.ObjectId,
+		Relation:  link.Relation,
+		CreatorId: link.CreatorId,
+		Name:      link.Name,
+		State:     link.State,
+	}
+}
+
+func userLinks[T matter.Detectable, PT relationLink[T]](ctx context.Context, userId bson.ObjectID) ([]ExportedLink, error) {
+	links, err := km.Detect[T](
+		km.Or(
+			km.Fld("subjid").Eq(userId),
+			km.Fld("creatorid").Eq(userId),
+		),
+	).Limit(MAX_EXPORT_ITEMS).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	retval := make([]ExportedLink, 0, len(links))
+	for i := range links {
+		retval = append(retval, exportLink(*PT(&links[i]).Link()))
+	}
+	return retval, nil
+}
+
+// ExportUserData collects the pages authored, comments written, relations
+// held or created, and reading lists of a user.
+func ExportUserData(ctx context.Context, userId bson.ObjectID, userName string) (*UserExport, error) {
+	export := &UserExport{UserId: userId, UserName: userName, GeneratedAt: time.Now().UTC()}
+
+	var err error
+	if userName != "" {
+		export.Pages, err = km.Detect[sitepages.Page](km.Fld("author").Eq(userName)).Limit(MAX_EXPORT_ITEMS).PullAll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Export pages: %v", err)
+		}
+
+		export.Comments, err = km.Detect[sitepages.Comment](km.Fld("user_name").Eq(userName)).Limit(MAX_EXPORT_ITEMS).PullAll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Export comments: %v", err)
+		}
+
+		export.Revisions, err = km.Detect[CommentRevision](km.Fld("user_name").Eq(userName)).Limit(MAX_EXPORT_ITEMS).PullAll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Export comment revisions: %v", err)
+		}
+		sortRevisions(export.Revisions)
+
+		export.Notified, err = km.Detect[Notification](km.Fld("recipient").Eq(userName)).Limit(MAX_EXPORT_ITEMS).PullAll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Export notifications: %v", err)
+		}
+	}
+
+	if export.PageLinks, err = userLinks[UserToPageLink](ctx, userId); err != nil {
+		return nil, fmt.Errorf("Export page relations: %v", err)
+	}
+	if export.CommentLinks, err = userLinks[UserToCommentLink](ctx, userId); err != nil {
+		return nil, fmt.Errorf("Export comment relations: %v", err)
+	}
+	if export.RootLinks, err = userLinks[UserToRootLink](ctx, userId); err != nil {
+		return nil, fmt.Errorf("Export root relations: %v", err)
+	}
+	if export.AuthorLinks, err = userLinks[UserToAuthorLink](ctx, userId); err != nil {
+		return nil, fmt.Errorf("Export author relations: %v", err)
+	}
+
+	export.Lists, err = km.Detect[ReadingList](km.Fld("OwnerId").Eq(userId)).Limit(MAX_LISTS_PER_USER).PullAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("Export lists: %v", err)
+	}
+
+	if len(export.Lists) > 0 {
+		listIds := make([]bson.ObjectID, 0, len(export.Lists))
+		for _, list := range export.Lists {
+			listIds = append(listIds, list.ID)
+		}
+
+		export.ListItems, err = km.Detect[ReadingListItem](
+			km.Fld("ListId").In(listIds),
+			km.Fld("removed").Ne(true),
+		).Limit(MAX_EXPORT_ITEMS).PullAll(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("Export list items: %v", err)
+		}
+		sortListItems(export.ListItems)
+	}
+	return export, nil
+}
+
+// WriteArchive writes the export as a zip holding export.json and a human
+// readable export.txt.
+func (e UserExport) WriteArchive(w io.Writer) error {
+	archive := zip.NewWriter(w)
+
+	file, err := archive.Create("export.json")
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(e); err != nil {
+		return err
+	}
+
+	file, err = archive.Create("export.txt")
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.WriteString(file, e.Text()); err != nil {
+		return err
+	}
+	return archive.Close()
+}
+
+func (e UserExport) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Data export for %s (%s)\n", e.UserName, e.UserId.Hex())
+	fmt.Fprintf(&b, "Generated %s\n", e.GeneratedAt.Format(time.RFC3339))
+
+	fmt.Fprintf(&b, "\n== Pages (%d) ==\n", len(e.Pages))
+	for _, page := range e.Pages {
+		fmt.Fprintf(&b, "\n%s  %s\n", page.EventAt.Format(time.RFC3339), page.Title)
+		fmt.Fprintf(&b, "  version %s of root %s\n", page.ID.Hex(), page.Root.Hex())
+		if page.Abstract != "" {
+			fmt.Fprintf(&b, "  %s\n", page.Abstract)
+		}
+	}
+
+	fmt.Fprintf(&b, "\n== Comments (%d) ==\n", len(e.Comments))
+	for _, comment := range e.Comments {
+		fmt.Fprintf(&b, "\n%s  on root %s\n", comment.EventAt.Format(time.RFC3339), comment.Root.Hex())
+		fmt.Fprintf(&b, "  %s\n", comment.Content)
+	}
+
+	fmt.Fprintf(&b, "\n== Comment revisions (%d) ==\n", len(e.Revisions))
+	for _, revision := range e.Revisions {
+		fmt.Fprintf(&b, "\n%s  of comment %s\n", revision.EventAt.Format(time.RFC3339), revision.CommentId.Hex())
+		fmt.Fprintf(&b, "  %s\n", revision.Content)
+	}
+
+	fmt.Fprintf(&b, "\n== Notifications (%d) ==\n", len(e.Notified))
+	for _, notification := range e.Notified {
+		fmt.Fprintf(&b, "%s  %s by %s on comment %s\n", notification.EventAt.Format(time.RFC3339), notification.Kind, notification.Actor, notification.CommentId.Hex())
+	}
+
+	writeLinks := func(title string, links []ExportedLink) {
+		fmt.Fprintf(&b, "\n== %s (%d) ==\n", title, len(links))
+		for _, link := range links {
+			line := fmt.Sprintf("%s %s", link.Relation, link.ObjectId.Hex())
+			if link.State != "" {
+				line += " (" + link.State + ")"
+			}
+			if link.Name != "" {
+				line += " in " + link.Name
+			}
+			fmt.Fprintf(&b, "%s\n", line)
+		}
+	}
+	writeLinks("Page relations", e.PageLinks)
+	writeLinks("Comment relations", e.CommentLinks)
+	writeLinks("Followed roots", e.RootLinks)
+	writeLinks("Followed authors", e.AuthorLinks)
+
+	fmt.Fprintf(&b, "\n== Reading lists (%d) ==\n", len(e.Lists))
+	for _, list := range e.Lists {
+		status := list.Visibility
+		if list.Deleted {
+			status += ", deleted"
+		}
+		fmt.Fprintf(&b, "\n%s (%s)\n", list.Name, status)
+		for _, item := range e.ListItems {
+			if item.ListId == list.ID {
+				fmt.Fprintf(&b, "  %s\n", item.Root.Hex())
+			}
+		}
+	}
+	return b.String()
+}
+
+// ServeUserExport downloads the data export of the authenticated user.
+func ServeUserExport(w http.ResponseWriter, r *http.Request) {
+	session, err := NewRequestContext(r).RequireUser()
+	if err != nil {
+		ServeError(w, err)
+		return
+	}
+
+	export, err := ExportUserData(r.Context(), session.UserId, session.UserName)
+	if err != nil {
+		ServeError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"export-%s.zip\"", export.GeneratedAt.Format("20060102")))
+	if err := export.WriteArchive(w); err != nil {
+		slog.Error("Error writing user export", slog.Any("error", err))
+	}
+}
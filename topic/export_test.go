@@ -0,0 +1,73 @@
+package topic
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestUserExportArchive(t *testing.T) {
+	page := sitepages.Page{Title: "My page", Author: "ada", Root: bson.NewObjectID()}
+	page.ID = bson.NewObjectID()
+	comment := sitepages.Comment{Content: "Nice read", UserName: "ada", Root: page.Root}
+	list := ReadingList{Name: "later", Visibility: ListPrivate}
+	list.ID = bson.NewObjectID()
+	deleted := ReadingList{Name: "old", Visibility: ListPublic, Deleted: true}
+	deleted.ID = bson.NewObjectID()
+	userId := bson.NewObjectID()
+
+	export := UserExport{
+		UserId:    userId,
+		UserName:  "ada",
+		Pages:     []sitepages.Page{page},
+		Comments:  []sitepages.Comment{comment},
+		PageLinks: []ExportedLink{exportLink(LinkDescription{SubjectId: userId, ObjectId: page.ID, Relation: RelationBookmarked, CreatorId: userId, Name: "later", State: RelationActive})},
+		Lists:     []ReadingList{list, deleted},
+		ListItems: []ReadingListItem{{ListId: list.ID, Root: page.Root}},
+	}
+
+	var buf bytes.Buffer
+	if err := export.WriteArchive(&buf); err != nil {
+		t.Fatalf("WriteArchive failed: %v", err)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("invalid zip archive: %v", err)
+	}
+
+	files := make(map[string]string)
+	for _, file := range archive.File {
+		reader, err := file.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(reader)
+		reader.Close()
+		files[file.Name] = string(content)
+	}
+
+	var decoded UserExport
+	if err := json.Unmarshal([]byte(files["export.json"]), &decoded); err != nil {
+		t.Fatalf("invalid export.json: %v", err)
+	}
+	if decoded.UserName != "ada" || len(decoded.Pages) != 1 || len(decoded.PageLinks) != 1 || len(decoded.ListItems) != 1 {
+		t.Errorf("unexpected decoded export %+v", decoded)
+	}
+	if link := decoded.PageLinks[0]; link.SubjectId != userId || link.CreatorId != userId || link.Name != "later" {
+		t.Errorf("expected the link subject, creator and list name in export.json, got %+v", link)
+	}
+
+	text := files["export.txt"]
+	for _, expected := range []string{"My page", "Nice read", "bookmarked " + page.ID.Hex() + " (active)", "later (private)", "old (public, deleted)", page.Root.Hex()} {
+		if !strings.Contains(text, expected) {
+			t.Errorf("expected export.txt to contain %q", expected)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+package topic
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_FACET_VALUES = 50
+
+const (
+	FacetTag      = "tag"
+	FacetCategory = "category"
+)
+
+type Facet struct {
+	XMLName xml.Name `xml:"facet" json:"-" bson:"-"`
+	Kind    string   `xml:"kind" json:"Kind" bson:"kind"`
+	Value   string   `xml:"value" json:"Value" bson:"value"`
+	Count   int      `xml:"count" json:"Count" bson:"count"`
+}
+
+// ByTagsFromQuery matches pages carrying any of the tags from the query
+// parameter, or all of them when the mode parameter is "all". Tags may be
+// repeated or comma separated.
+func ByTagsFromQuery(queryName string, modeName string) Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		query := s.URLQuery()
+		var tags []string
+		for _, value := range query[queryName] {
+			for tag := range strings.SplitSeq(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = appendUnique(tags, tag)
+				}
+			}
+		}
+
+		if len(tags) == 0 {
+			return nil, NewMissingInput(fmt.Sprintf("missing query parameter: %s", queryName), http.StatusBadRequest)
+		}
+
+		if len(tags) > MAX_QUERY_IN_SIZE {
+			return nil, NewStatusString("too many tags in query", http.StatusBadRequest)
+		}
+
+		switch query.Get(modeName) {
+		case "", "any":
+			pred := km.Fld("infos.tags").In(tags)
+			return &pred, nil
+		case "all":
+			predicates := make([]expression.QueryFieldPredicate, 0, len(tags))
+			for _, tag := range tags {
+				predicates = append(predicates, km.Fld("infos.tags").Eq(tag))
+			}
+			return combinePredicates(predicates, km.And), nil
+		}
+
+		return nil, NewStatusString("invalid tag mode", http.StatusBadRequest)
+	}
+}
+
+func ByCategoryFromQuery(queryName string) Filter {
+	return Fld("infos.category").ByQueryValues(queryName)
+}
+
+type facetRow struct {
+	Tags     []string `bson:"tags,omitempty"`
+	Category string   `bson:"category,omitempty"`
+	Count    int      `bson:"count"`
+}
+
+func (r facetRow) GetID() bson.ObjectID {
+	return bson.NilObjectID
+}
+
+func (r facetRow) HasID() bool {
+	return false
+}
+
+func (r facetRow) LastObserved() time.Time {
+	return time.Time{}
+}
+
+// PageFacets counts roots per tag and per category among the roots whose
+// latest version matches predicates. Each root counts once, with its latest
+// version: a root whose latest version dropped a tag no longer counts for it,
+// even if an older version matches. The tag arrays are split here.
+func PageFacets(ctx context.Context, predicates ...expression.QueryFieldPredicate) ([]Facet, error) {
+	matching, err := km.ProjectInto[latestVersion](
+		km.Fld("root").With().GroupKey("root"),
+	).From(
+		km.Detect[sitepages.Page](predicates...).GroupBy("root"),
+	).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]bson.ObjectID, 0, len(matching))
+	for _, row := range matching {
+		roots = append(roots, row.Root)
+	}
+
+	versions, err := latestVersions(ctx, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return []Facet{}, nil
+	}
+
+	latest := make([]bson.ObjectID, 0, len(versions))
+	for _, version := range versions {
+		latest = append(latest, version.Latest)
+	}
+
+	rows, err := km.ProjectInto[facetRow](
+		km.Fld("tags").With().GroupKey("infos.tags"),
+		km.Fld("category").With().GroupKey("infos.category"),
+		km.Fld("count").With().One(),
+	).From(
+		km.Detect[sitepages.Page](
+			append(slices.Clip(predicates), km.Fld("ID").In(latest))...,
+		).GroupBy(
+			"infos.tags", "infos.category",
+		).Accumulate(
+			km.Fld("count").With().Sum(1),
+		),
+	).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	facets := tallyFacets(FacetTag, rows, func(r facetRow) []string { return r.Tags })
+	facets = append(facets, tallyFacets(FacetCategory, rows, func(r facetRow) []string {
+		if r.Category == "" {
+			return nil
+		}
+		return []string{r.Category}
+	})...)
+	return facets, nil
+}
+
+// tallyFacets adds up the root counts of the rows per value.
+func tallyFacets(kind string, rows []facetRow, values func(facetRow) []string) []Facet {
+	counts := make(map[string]int)
+	for _, row := range rows {
+		var seen []string
+		for _, value := range values(row) {
+			if slices.Contains(seen, value) {
+				continue
+			}
+			seen = append(seen, value)
+			counts[value] += row.Count
+		}
+	}
+
+	facets := make([]Facet, 0, len(counts))
+	for value, count := range counts {
+		facets = append(facets, Facet{Kind: kind, Value: value, Count: count})
+	}
+
+	slices.SortFunc(facets, func(a, b Facet) int {
+		if c := cmp.Compare(b.Count, a.Count); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Value, b.Value)
+	})
+
+	if len(facets) > MAX_FACET_VALUES {
+		facets = facets[:MAX_FACET_VALUES]
+	}
+	return facets
+}
+
+func PullPageFacets(filters ...Filter) HandlerFunc[Page] {
+	return func(s *Session[Page]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Facet Session missing Response structure")
+		}
+
+		predicates, err := ExpressFilter(s.RequestContext, filters...)
+		if err != nil {
+			return err
+		}
+
+		facets, err := PageFacets(s.Request.Context(), predicates...)
+		if err != nil {
+			return fmt.Errorf("PageFacets request error: %v", err)
+		}
+
+		for _, facet := range facets {
+			s.Response.Append(facet)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,42 @@
+package topic
+
+import (
+	"testing"
+)
+
+func TestTallyFacets(t *testing.T) {
+	rows := []facetRow{
+		{Tags: []string{"go", "db"}, Category: "guide", Count: 2},
+		{Tags: []string{"go", "web", "go"}, Count: 1},
+		{Tags: []string{"web"}, Category: "guide", Count: 1},
+	}
+
+	facets := tallyFacets(FacetTag, rows, func(r facetRow) []string { return r.Tags })
+	if len(facets) != 3 {
+		t.Fatalf("expected 3 facets, got %d", len(facets))
+	}
+
+	if facets[0].Value != "go" || facets[0].Count != 3 {
+		t.Errorf("expected go counted once per root, got %v", facets[0])
+	}
+
+	if facets[1].Value != "db" || facets[1].Count != 2 || facets[2].Value != "web" || facets[2].Count != 2 {
+		t.Errorf("expected ties ordered by value, got %v", facets[1:])
+	}
+
+	for _, facet := range facets {
+		if facet.Kind != FacetTag {
+			t.Errorf("expected tag facet kind, got %s", facet.Kind)
+		}
+	}
+
+	categories := tallyFacets(FacetCategory, rows, func(r facetRow) []string {
+		if r.Category == "" {
+			return nil
+		}
+		return []string{r.Category}
+	})
+	if len(categories) != 1 || categories[0].Value != "guide" || categories[0].Count != 3 {
+		t.Errorf("expected guide counted over its roots, got %v", categories)
+	}
+}
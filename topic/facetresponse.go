@@ -0,0 +1,27 @@
+package topic
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type FacetTopicResponse struct {
+	EntangledResponse
+	FacetData []Facet `xml:"-" json:"FacetData,omitempty" bson:"-" `
+}
+
+func NewFacetTopicResponse() Response {
+	return &FacetTopicResponse{}
+}
+
+func (fr *FacetTopicResponse) Append(data any) bson.ObjectID {
+	switch response := data.(type) {
+	case Facet:
+		fr.FacetData = append(fr.FacetData, response)
+		return bson.NilObjectID
+	case *Facet:
+		fr.FacetData = append(fr.FacetData, *response)
+		return bson.NilObjectID
+	}
+
+	return fr.EntangledResponse.Append(data)
+}
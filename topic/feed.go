@@ -0,0 +1,350 @@
+package topic
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_FOLLOWS int64 = 500
+var MAX_FEED_PAGE int64 = 100
+
+// FEED_DEFAULT_SINCE bounds the feed of a user without a recorded visit.
+var FEED_DEFAULT_SINCE = 7 * 24 * time.Hour
+
+const (
+	FeedPage    = "page"
+	FeedComment = "comment"
+)
+
+// FeedVisit remembers the newest item a user was served from the start of
+// their feed.
+type FeedVisit struct {
+	km.BaseModel `bson:",inline" kosmos:"feed_visit"`
+	UserId       bson.ObjectID `bson:"user_id"`
+	LastVisit    time.Time     `bson:"last_visit"`
+}
+
+func (f FeedVisit) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		km.Fld("UserId").Eq(f.UserId),
+	)
+}
+
+type FeedItem struct {
+	XMLName xml.Name      `xml:"feeditem" json:"-" bson:"-"`
+	Kind    string        `xml:"kind" json:"Kind"`
+	ID      bson.ObjectID `xml:"id" json:"ID"`
+	Root    bson.ObjectID `xml:"root" json:"root"`
+	Author  string        `xml:"author,omitempty" json:"author,omitempty"`
+	EventAt time.Time     `xml:"eventat" json:"eventat"`
+}
+
+// feedCursor continues a feed after the item at (EventAt, ID), keeping the
+// lower time bound of the first page so later pages do not move with visits.
+type feedCursor struct {
+	Since   time.Time
+	EventAt time.Time
+	ID      bson.ObjectID
+}
+
+func (c feedCursor) String() string {
+	return fmt.Sprintf("%d.%d.%s", c.Since.UnixNano(), c.EventAt.UnixNano(), c.ID.Hex())
+}
+
+func parseFeedCursor(value string) (*feedCursor, error) {
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed feed cursor")
+	}
+
+	since, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed feed cursor time: %v", err)
+	}
+
+	eventAt, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed feed cursor time: %v", err)
+	}
+
+	id, err := bson.ObjectIDFromHex(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed feed cursor id: %v", err)
+	}
+
+	return &feedCursor{Since: time.Unix(0, since).UTC(), EventAt: time.Unix(0, eventAt).UTC(), ID: id}, nil
+}
+
+// before orders feed items newest first, ties broken by descending ID.
+func (f FeedItem) before(other FeedItem) bool {
+	if !f.EventAt.Equal(other.EventAt) {
+		return f.EventAt.After(other.EventAt)
+	}
+	return f.ID.Hex() > other.ID.Hex()
+}
+
+// mergeFeed merges the newest-first pages and comments into at most limit
+// items. The cursor is empty when there is nothing left to read.
+func mergeFeed(pages []Page, comments []Comment, limit int, since time.Time) ([]FeedItem, string) {
+	items := make([]FeedItem, 0, len(pages)+len(comments))
+	for _, page := range pages {
+		items = append(items, FeedItem{Kind: FeedPage, ID: page.ID, Root: page.Root, Author: page.Author, EventAt: page.EventAt})
+	}
+	for _, comment := range comments {
+		items = append(items, FeedItem{Kind: FeedComment, ID: comment.ID, Root: comment.Root, Author: comment.UserName, EventAt: comment.EventAt})
+	}
+
+	sortFeed(items)
+	if len(items) <= limit {
+		return items, ""
+	}
+
+	items = items[:limit]
+	last := items[len(items)-1]
+	return items, feedCursor{Since: since, EventAt: last.EventAt, ID: last.ID}.String()
+}
+
+// nextVisit is the visit marker after serving the first page of items: the
+// newest item served, when it is newer than the previous marker. The feed
+// starts at the marker inclusively, so items sharing its time are not lost.
+func nextVisit(items []FeedItem, previous time.Time) (time.Time, bool) {
+	if len(items) == 0 || !items[0].EventAt.After(previous) {
+		return previous, false
+	}
+	return items[0].EventAt, true
+}
+
+func sortFeed(items []FeedItem) {
+	slices.SortFunc(items, func(a, b FeedItem) int {
+		switch {
+		case a.before(b):
+			return -1
+		case b.before(a):
+			return 1
+		}
+		return 0
+	})
+}
+
+type followed struct {
+	roots   []bson.ObjectID
+	authors []string
+}
+
+func loadFollowed(ctx context.Context, userId bson.ObjectID) (*followed, error) {
+	rootLinks, err := km.Detect[UserToRootLink](
+		km.Fld("subjid").Eq(userId),
+		km.Fld("relation").Eq(RelationFollowing),
+		km.Fld("state").Eq(RelationActive),
+	).Limit(MAX_FOLLOWS).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	authorLinks, err := km.Detect[UserToAuthorLink](
+		km.Fld("subjid").Eq(userId),
+		km.Fld("relation").Eq(RelationFollowing),
+		km.Fld("state").Eq(RelationActive),
+	).Limit(MAX_FOLLOWS).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	retval := &followed{}
+	for _, link := range rootLinks {
+		retval.roots = append(retval.roots, link.ObjectId)
+	}
+
+	if len(authorLinks) == 0 {
+		return retval, nil
+	}
+
+	statIds := make([]bson.ObjectID, 0, len(authorLinks))
+	for _, link := range authorLinks {
+		statIds = append(statIds, link.ObjectId)
+	}
+
+	authors, err := km.Detect[AuthorStat](
+		km.Fld("ID").In(statIds),
+	).Limit(int64(len(statIds))).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, author := range authors {
+		retval.authors = append(retval.authors, author.Name)
+	}
+	return retval, nil
+}
+
+// feedPredicates selects the items of the followed roots or authors (by the
+// authorField) within the time bounds of the cursor, excluding the user's own.
+func feedPredicates(follows *followed, authorField string, self string, since time.Time, cursor *feedCursor) []expression.QueryFieldPredicate {
+	var sources []expression.QueryFieldPredicate
+	if len(follows.roots) > 0 {
+		sources = append(sources, km.Fld("Root").ID().In(follows.roots))
+	}
+	if len(follows.authors) > 0 {
+		sources = append(sources, km.Fld(authorField).In(follows.authors))
+	}
+
+	predicates := []expression.QueryFieldPredicate{
+		*combinePredicates(sources, km.Or),
+		km.Fld("EventAt").Gte(since),
+	}
+
+	if self != "" {
+		predicates = append(predicates, km.Fld(authorField).Ne(self))
+	}
+
+	if cursor != nil {
+		predicates = append(predicates, km.Or(
+			km.Fld("EventAt").Lt(cursor.EventAt),
+			km.And(
+				km.Fld("EventAt").Eq(cursor.EventAt),
+				km.Fld("ID").Lt(cursor.ID),
+			),
+		))
+	}
+	return predicates
+}
+
+// PullFollowFeed returns the page versions and comments of the roots and
+// authors the user follows, newest first, since the user's last visit (or the
+// "since" query parameter). The "cursor" query parameter continues a previous
+// response. Reading the first page from the last visit advances the visit to
+// the newest item served.
+func PullFollowFeed(limit int64) HandlerFunc[FeedVisit] {
+	return func(s *Session[FeedVisit]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Feed Session missing Response structure")
+		}
+
+		session, err := s.RequireUser()
+		if err != nil {
+			return err
+		}
+
+		limit := min(limit, MAX_FEED_PAGE)
+		if value := s.URLQuery().Get("limit"); value != "" {
+			requested, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || requested <= 0 {
+				return NewStatusString("invalid limit", http.StatusBadRequest)
+			}
+			limit = min(limit, requested)
+		}
+
+		ctx := s.Request.Context()
+		now := time.Now().UTC()
+
+		var cursor *feedCursor
+		var since time.Time
+		var lastVisit time.Time
+		fromVisit := false
+		if value := s.URLQuery().Get("cursor"); value != "" {
+			cursor, err = parseFeedCursor(value)
+			if err != nil {
+				return NewStatusError(err, http.StatusBadRequest)
+			}
+			since = cursor.Since
+		} else if value := s.URLQuery().Get("since"); value != "" {
+			since, err = ParseTimeBound(value, now)
+			if err != nil {
+				return NewStatusError(err, http.StatusBadRequest)
+			}
+		} else {
+			fromVisit = true
+			since = now.Add(-FEED_DEFAULT_SINCE)
+			visits, err := km.Detect[FeedVisit](km.Fld("UserId").Eq(session.UserId)).Limit(1).PullAll(ctx)
+			if err != nil {
+				return fmt.Errorf("FeedVisit request error: %v", err)
+			}
+			if len(visits) > 0 {
+				lastVisit = visits[0].LastVisit
+			}
+			if lastVisit.After(since) {
+				since = lastVisit
+			}
+		}
+
+		if now.Sub(since) > MAX_TIME_WINDOW {
+			return NewStatusString("time window too large", http.StatusBadRequest)
+		}
+
+		follows, err := loadFollowed(ctx, session.UserId)
+		if err != nil {
+			return fmt.Errorf("Feed follows request error: %v", err)
+		}
+
+		var pages []Page
+		var comments []Comment
+		if len(follows.roots) > 0 || len(follows.authors) > 0 {
+			// one extra item tells whether another page follows
+			pages, err = km.Detect[Page](
+				feedPredicates(follows, "author", session.UserName, since, cursor)...,
+			).Sort(newestFirst()...).Limit(limit + 1).PullAll(ctx)
+			if err != nil {
+				return fmt.Errorf("Feed pages request error: %v", err)
+			}
+
+			comments, err = km.Detect[Comment](
+				append(feedPredicates(follows, "user_name", session.UserName, since, cursor), visibleComments())...,
+			).Sort(newestFirst()...).Limit(limit + 1).PullAll(ctx)
+			if err != nil {
+				return fmt.Errorf("Feed comments request error: %v", err)
+			}
+		}
+
+		items, next := mergeFeed(pages, comments, int(limit), since)
+
+		pageByID := make(map[bson.ObjectID]Page, len(pages))
+		for _, page := range pages {
+			pageByID[page.ID] = page
+		}
+		commentByID := make(map[bson.ObjectID]Comment, len(comments))
+		for _, comment := range comments {
+			commentByID[comment.ID] = comment
+		}
+
+		for _, item := range items {
+			s.Response.Append(item)
+			switch item.Kind {
+			case FeedPage:
+				page := pageByID[item.ID]
+				if err := page.Sanitize(s.RequestContext); err != nil {
+					return err
+				}
+				s.Response.Append(page)
+			case FeedComment:
+				comment := commentByID[item.ID]
+				if err := comment.Sanitize(s.RequestContext); err != nil {
+					return err
+				}
+				s.Response.Append(comment)
+			}
+		}
+
+		if feed, ok := s.Response.(*FeedTopicResponse); ok {
+			feed.Cursor = next
+		}
+
+		// only what was served counts as read, not everything up to now
+		if visited, ok := nextVisit(items, lastVisit); fromVisit && ok {
+			visit := FeedVisit{UserId: session.UserId, LastVisit: visited}
+			if err := km.Record(ctx, &visit); err != nil {
+				return fmt.Errorf("FeedVisit record error: %v", err)
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package topic
+
+import (
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestMergeFeed(t *testing.T) {
+	since := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	at := func(hours int) time.Time {
+		return since.Add(time.Duration(hours) * time.Hour)
+	}
+
+	page := func(eventAt time.Time) Page {
+		p := Page{EventAt: eventAt}
+		p.ID = bson.NewObjectID()
+		return p
+	}
+	comment := func(eventAt time.Time) Comment {
+		c := Comment{EventAt: eventAt}
+		c.ID = bson.NewObjectID()
+		return c
+	}
+
+	pages := []Page{page(at(5)), page(at(3))}
+	comments := []Comment{comment(at(4)), comment(at(3)), comment(at(1))}
+
+	items, cursor := mergeFeed(pages, comments, 3, since)
+	if len(items) != 3 || cursor == "" {
+		t.Fatalf("expected a full page with a cursor, got %d items %q", len(items), cursor)
+	}
+
+	if items[0].Kind != FeedPage || items[1].Kind != FeedComment || !items[2].EventAt.Equal(at(3)) {
+		t.Errorf("unexpected feed order %+v", items)
+	}
+
+	// pages[1] and comments[1] share a time; the higher ID comes first
+	tiedFirst, tiedSecond := pages[1].ID, comments[1].ID
+	if tiedFirst.Hex() < tiedSecond.Hex() {
+		tiedFirst, tiedSecond = tiedSecond, tiedFirst
+	}
+	if items[2].ID != tiedFirst {
+		t.Errorf("expected equal times to be ordered by descending ID, got %s", items[2].ID.Hex())
+	}
+
+	parsed, err := parseFeedCursor(cursor)
+	if err != nil {
+		t.Fatalf("parseFeedCursor failed: %v", err)
+	}
+	if !parsed.Since.Equal(since) || !parsed.EventAt.Equal(items[2].EventAt) || parsed.ID != items[2].ID {
+		t.Errorf("cursor does not point at the last item: %+v", parsed)
+	}
+
+	items, _ = mergeFeed(pages, comments, 4, since)
+	if len(items) != 4 || items[2].ID != tiedFirst || items[3].ID != tiedSecond {
+		t.Errorf("expected both tied items in ID order, got %+v", items)
+	}
+
+	if _, err := parseFeedCursor("not-a-cursor"); err == nil {
+		t.Errorf("expected malformed cursor to be rejected")
+	}
+
+	items, cursor = mergeFeed(pages[:1], nil, 3, since)
+	if len(items) != 1 || cursor != "" {
+		t.Errorf("expected last page without cursor, got %d items %q", len(items), cursor)
+	}
+}
+
+func TestNextVisit(t *testing.T) {
+	previous := time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)
+	items := []FeedItem{{EventAt: previous.Add(2 * time.Hour)}, {EventAt: previous.Add(time.Hour)}}
+
+	visited, ok := nextVisit(items, previous)
+	if !ok || !visited.Equal(items[0].EventAt) {
+		t.Errorf("expected the visit to advance to the newest item served, got %v %v", visited, ok)
+	}
+
+	if _, ok := nextVisit(nil, previous); ok {
+		t.Errorf("expected an empty page not to advance the visit")
+	}
+
+	if _, ok := nextVisit(items, previous.Add(3*time.Hour)); ok {
+		t.Errorf("expected the visit never to move back")
+	}
+}
@@ -0,0 +1,28 @@
+package topic
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type FeedTopicResponse struct {
+	EntangledResponse
+	FeedData []FeedItem `xml:"-" json:"FeedData,omitempty" bson:"-" `
+	Cursor   string     `xml:"-" json:"Cursor,omitempty" bson:"-" `
+}
+
+func NewFeedTopicResponse() Response {
+	return &FeedTopicResponse{}
+}
+
+func (fr *FeedTopicResponse) Append(data any) bson.ObjectID {
+	switch response := data.(type) {
+	case FeedItem:
+		fr.FeedData = append(fr.FeedData, response)
+		return response.ID
+	case *FeedItem:
+		fr.FeedData = append(fr.FeedData, *response)
+		return response.ID
+	}
+
+	return fr.EntangledResponse.Append(data)
+}
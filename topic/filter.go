@@ -1,6 +1,7 @@
 package topic
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 
@@ -9,8 +10,104 @@ import (
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+// Filter builds the predicate of a query from the request. A filter yielding
+// no predicate is skipped: it does not constrain the query. Every combinator
+// follows this rule, so a skipped optional filter (absent input) behaves the
+// same inside Not, AllOf and AnyOf as on its own.
 type Filter func(context RequestContext) (*expression.QueryFieldPredicate, error)
 
+// MissingInputResponse reports that a filter could not be built because its
+// input (path value, query value or user identity) is absent from the request.
+// Optional filters treat it as "no constraint".
+type MissingInputResponse struct {
+	StatusResponse
+}
+
+func NewMissingInput(message string, code int) ErrorResponse {
+	return &MissingInputResponse{StatusResponse{StatusCode: code, StatusMsg: message}}
+}
+
+func IsMissingInput(err error) bool {
+	var missing *MissingInputResponse
+	return errors.As(err, &missing)
+}
+
+// Optional skips the filter when its input is absent instead of failing the request.
+func (f Filter) Optional() Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		pred, err := f(s)
+		if IsMissingInput(err) {
+			return nil, nil
+		}
+		return pred, err
+	}
+}
+
+func (f Filter) And(others ...Filter) Filter {
+	return AllOf(append([]Filter{f}, others...)...)
+}
+
+func (f Filter) Or(others ...Filter) Filter {
+	return AnyOf(append([]Filter{f}, others...)...)
+}
+
+// Not negates the filter. A skipped filter stays skipped.
+func (f Filter) Not() Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		pred, err := f(s)
+		if err != nil || pred == nil {
+			return nil, err
+		}
+
+		negated := kosmos.Nor(*pred)
+		return &negated, nil
+	}
+}
+
+func Not(filter Filter) Filter {
+	return filter.Not()
+}
+
+// AllOf matches when every filter matches. Skipped filters are left out; when
+// all are skipped, so is AllOf.
+func AllOf(filters ...Filter) Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		predicates, err := ExpressFilter(s, filters...)
+		if err != nil {
+			return nil, err
+		}
+		return combinePredicates(predicates, kosmos.And), nil
+	}
+}
+
+// AnyOf matches when at least one filter matches. Skipped filters are left out
+// of the alternatives; when all are skipped, so is AnyOf.
+func AnyOf(filters ...Filter) Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		predicates, err := ExpressFilter(s, filters...)
+		if err != nil {
+			return nil, err
+		}
+		return combinePredicates(predicates, kosmos.Or), nil
+	}
+}
+
+func Or(filters ...Filter) Filter {
+	return AnyOf(filters...)
+}
+
+func combinePredicates(predicates []expression.QueryFieldPredicate, combine func(...expression.QueryFieldPredicate) expression.QueryFieldPredicate) *expression.QueryFieldPredicate {
+	switch len(predicates) {
+	case 0:
+		return nil
+	case 1:
+		return &predicates[0]
+	}
+
+	pred := combine(predicates...)
+	return &pred
+}
+
 func ExpressFilter(context RequestContext, filters ...Filter) ([]expression.QueryFieldPredicate, error) {
 	predicates := []expression.QueryFieldPredicate{}
 
@@ -53,7 +150,7 @@ func ByRootID(ignoreZero bool) Filter {
 			return nil, nil
 		}
 
-		pred := kosmos.Fld("ID").Eq(s.TopicId)
+		pred := kosmos.Fld("Root").ID().Eq(*s.RootId)
 		return &pred, nil
 	}
 }
@@ -70,7 +167,7 @@ func (f FieldPredicate) ByIDFromPath(pathName string) Filter {
 	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
 		idStr := s.Request.PathValue(pathName)
 		if idStr == "" {
-			return nil, NewStatusString("empty id from path", http.StatusBadRequest)
+			return nil, NewMissingInput("empty id from path", http.StatusBadRequest)
 		}
 
 		id, err := bson.ObjectIDFromHex(idStr)
@@ -119,9 +216,13 @@ func (f FieldPredicate) ByAuthID(allowUserZero bool) Filter {
 			return nil, NewStatusError(err, http.StatusUnauthorized)
 		}
 
+		if clientSession == nil {
+			return nil, NewMissingInput("missing user session", http.StatusUnauthorized)
+		}
+
 		userid := clientSession.UserId
 		if !allowUserZero && userid.IsZero() {
-			return nil, NewStatusError(fmt.Errorf("Failed to filter. User id is zero"), http.StatusUnauthorized)
+			return nil, NewMissingInput("Failed to filter. User id is zero", http.StatusUnauthorized)
 		}
 
 		pred := kosmos.Fld(f.FieldName).Eq(userid)
@@ -136,9 +237,13 @@ func (f FieldPredicate) ByAuthName() Filter {
 			return nil, NewStatusError(err, http.StatusUnauthorized)
 		}
 
+		if clientSession == nil {
+			return nil, NewMissingInput("missing user session", http.StatusUnauthorized)
+		}
+
 		username := clientSession.UserName
 		if clientSession.UserName == "" {
-			return nil, NewStatusError(fmt.Errorf("missing required auth parameter: user_name"), http.StatusUnauthorized)
+			return nil, NewMissingInput("missing required auth parameter: user_name", http.StatusUnauthorized)
 		}
 
 		pred := kosmos.Fld(f.FieldName).Eq(username)
@@ -154,6 +259,32 @@ func (f FieldPredicate) Eq(value any) Filter {
 	}
 }
 
+func (f FieldPredicate) In(values any) Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		pred := kosmos.Fld(f.FieldName).In(values)
+		return &pred, nil
+	}
+}
+
+// ByQueryValues matches any of the values of the query parameter. Absent
+// parameters report missing input so the filter can be made Optional.
+func (f FieldPredicate) ByQueryValues(queryName string) Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		values := s.URLQuery()[queryName]
+		if len(values) == 0 {
+			return nil, NewMissingInput(fmt.Sprintf("missing query parameter: %s", queryName), http.StatusBadRequest)
+		}
+
+		var pred expression.QueryFieldPredicate
+		if len(values) == 1 {
+			pred = kosmos.Fld(f.FieldName).Eq(values[0])
+		} else {
+			pred = kosmos.Fld(f.FieldName).In(values)
+		}
+		return &pred, nil
+	}
+}
+
 // type FilterSession struct {
 // 	Filters []expression.QueryFieldPredicate
 // }
@@ -0,0 +1,424 @@
+package topic
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"git.mypierian.com/borghives/websession"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+const (
+	CommentVisible = "visible"
+	CommentPending = "pending"
+	CommentHidden  = "hidden"
+	CommentRemoved = "removed"
+)
+
+const (
+	ModerateApprove = "approve"
+	ModerateHide    = "hide"
+	ModerateRemove  = "remove"
+)
+
+var moderationStates = map[string]string{
+	ModerateApprove: CommentVisible,
+	ModerateHide:    CommentHidden,
+	ModerateRemove:  CommentRemoved,
+}
+
+// HOLD_FIRST_COMMENTS holds the comments of a user for moderation until one
+// of them has been approved.
+var HOLD_FIRST_COMMENTS = true
+
+// REPORT_THRESHOLD is the number of active reports that brings a visible
+// comment to the moderation queue.
+var REPORT_THRESHOLD = 1
+
+var MAX_MODERATION_QUEUE int64 = 200
+
+// IsModerator decides whether a verified user may moderate. Nobody may when
+// it is nil.
+var IsModerator func(session *websession.Session) bool
+
+// commentModeration records only the moderation fields of a comment.
+type commentModeration struct {
+	km.BaseModel `bson:",inline" kosmos:"comment"`
+	State        string    `bson:"state"`
+	ModeratedBy  string    `bson:"moderated_by"`
+	ModeratedAt  time.Time `bson:"moderated_at"`
+}
+
+// commentCredit marks that a comment was credited to its root activity and
+// its author; its ID is the comment ID. Like relationCredit, every crediting
+// write increments Grants and reads it back, so only the first credits.
+type commentCredit struct {
+	km.BaseModel `bson:",inline" kosmos:"comment_credit"`
+	Grants       int `bson:"grants"`
+}
+
+func (c *commentCredit) Collapse() matter.Ripple {
+	ripple := c.BaseModel.Collapse()
+	ripple.DoIncr("grants", 1)
+	return ripple
+}
+
+func (c *commentCredit) Decohere(ripple matter.Ripple) error {
+	if grants, ok := ripple.Get("grants"); ok {
+		c.Grants = grants.(int)
+	}
+	return c.BaseModel.Decohere(ripple)
+}
+
+// creditComment records the root activity and the author contribution of a
+// comment the first time it is visible. Held comments are credited once
+// approved; hiding, removing, editing or approving again credits nothing. It
+// only logs on failure.
+func creditComment(ctx context.Context, comment Comment) {
+	if comment.CommentState() != CommentVisible {
+		return
+	}
+
+	credit := commentCredit{}
+	credit.ID = comment.ID
+	if err := km.Record(ctx, &credit); err != nil {
+		log.Printf("Comment: failed to record credit %v", err)
+		return
+	}
+
+	if credit.Grants != 1 {
+		return
+	}
+
+	if err := RecordActivity(ctx, comment.Root, ActivityComment); err != nil {
+		log.Printf("Comment: failed to record root activity %v", err)
+	}
+
+	if err := RecordAuthorContribution(ctx, comment.UserName, AuthorComment, 1); err != nil {
+		log.Printf("Comment: failed to record author stat %v", err)
+	}
+}
+
+// CommentState is the moderation state of the comment. Comments recorded
+// before moderation have none and are visible.
+func (c Comment) CommentState() string {
+	if c.State == "" {
+		return CommentVisible
+	}
+	return c.State
+}
+
+// VisibleTo reports whether the comment is shown to a user. Pending and hidden
+// comments are shown to their author and to moderators only; removed comments
+// to moderators only.
+func (c Comment) VisibleTo(userName string, moderator bool) bool {
+	switch c.CommentState() {
+	case CommentVisible:
+		return true
+	case CommentRemoved:
+		return moderator
+	}
+	return moderator || (userName != "" && userName == c.UserName)
+}
+
+func (c Comment) VisibleFilter() Filter {
+	return ByVisibleComments()
+}
+
+// visibleComments matches the comments shown to everyone.
+func visibleComments() expression.QueryFieldPredicate {
+	return km.Or(
+		km.Fld("state").Exists(false),
+		km.Fld("state").Eq(CommentVisible),
+	)
+}
+
+// commentsVisibleTo matches the comments shown to a user as VisibleTo does:
+// none is needed for moderators.
+func commentsVisibleTo(userName string, moderator bool) []expression.QueryFieldPredicate {
+	if moderator {
+		return nil
+	}
+
+	if userName == "" {
+		return []expression.QueryFieldPredicate{visibleComments()}
+	}
+
+	return []expression.QueryFieldPredicate{km.Or(
+		visibleComments(),
+		km.And(
+			km.Fld("user_name").Eq(userName),
+			km.Fld("state").Ne(CommentRemoved),
+		),
+	)}
+}
+
+// ByVisibleComments restricts a comment query to the comments shown to the
+// user: the visible ones and their own held ones, or all for moderators.
+func ByVisibleComments() Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		predicates := commentsVisibleTo(s.Viewer())
+		if len(predicates) == 0 {
+			return nil, nil
+		}
+		return &predicates[0], nil
+	}
+}
+
+// initialCommentState holds the comment of a user without any visible comment.
+func initialCommentState(ctx context.Context, userName string) (string, error) {
+	if !HOLD_FIRST_COMMENTS {
+		return CommentVisible, nil
+	}
+
+	previous, err := km.Detect[sitepages.Comment](
+		km.Fld("user_name").Eq(userName),
+		visibleComments(),
+	).Limit(1).PullAll(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	if len(previous) == 0 {
+		return CommentPending, nil
+	}
+	return CommentVisible, nil
+}
+
+// reportCounts counts the active reports of the comments ids.
+func reportCounts(ctx context.Context, ids []bson.ObjectID) ([]RelationCount, error) {
+	return km.ProjectInto[RelationCount](
+		km.Fld("objid").With().GroupKey("objid"),
+		km.Fld("relation").With().GroupKey("relation"),
+		km.Fld("count").With().One(),
+	).From(
+		km.Detect[UserToCommentLink](
+			km.Fld("objid").In(ids),
+			km.Fld("relation").Eq(RelationReported),
+			km.Fld("state").Eq(RelationActive),
+		).GroupBy(
+			"objid", "relation",
+		).Accumulate(
+			km.Fld("count").With().Sum(1),
+		),
+	).PullAll(ctx)
+}
+
+// reportedCommentIds returns the distinct comments of the oldest limit active
+// reports.
+func reportedCommentIds(ctx context.Context, limit int64) ([]bson.ObjectID, error) {
+	reports, err := km.Detect[UserToCommentLink](
+		km.Fld("relation").Eq(RelationReported),
+		km.Fld("state").Eq(RelationActive),
+	).Sort(km.Fld("ID").Asc()).Limit(limit).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var retval []bson.ObjectID
+	for _, report := range reports {
+		if !slices.Contains(retval, report.ObjectId) {
+			retval = append(retval, report.ObjectId)
+		}
+	}
+	return retval, nil
+}
+
+// compareQueued orders the moderation queue oldest first by moment, as the
+// pending comments are read.
+func compareQueued(a Comment, b Comment) int {
+	if c := cmp.Compare(a.Moment, b.Moment); c != 0 {
+		return c
+	}
+	return bytes.Compare(a.ID[:], b.ID[:])
+}
+
+// PullModerationQueue returns the pending comments and the visible comments
+// reported at least REPORT_THRESHOLD times, oldest first, with their report
+// counts. Reported comments are looked for among the oldest active reports.
+func PullModerationQueue(limit int64) HandlerFunc[Comment] {
+	return func(s *Session[Comment]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Moderation Session missing Response structure")
+		}
+
+		if _, err := s.RequireModerator(); err != nil {
+			return err
+		}
+
+		ctx := s.Request.Context()
+		limit := min(limit, MAX_MODERATION_QUEUE)
+
+		queue, err := km.Detect[Comment](
+			km.Fld("state").Eq(CommentPending),
+		).Sort(km.Fld("Moment").Asc(), km.Fld("ID").Asc()).Limit(limit).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("Moderation pending request error: %v", err)
+		}
+
+		candidates, err := reportedCommentIds(ctx, MAX_MODERATION_QUEUE)
+		if err != nil {
+			return fmt.Errorf("Moderation report request error: %v", err)
+		}
+
+		var reported []bson.ObjectID
+		if len(candidates) > 0 {
+			counts, err := reportCounts(ctx, candidates)
+			if err != nil {
+				return fmt.Errorf("Moderation report request error: %v", err)
+			}
+			for _, count := range counts {
+				if count.Count >= REPORT_THRESHOLD {
+					reported = append(reported, count.ObjectId)
+				}
+			}
+		}
+
+		if len(reported) > 0 {
+			comments, err := km.Detect[Comment](
+				km.Fld("ID").In(reported),
+				visibleComments(),
+			).Sort(km.Fld("Moment").Asc(), km.Fld("ID").Asc()).Limit(limit).PullAll(ctx)
+			if err != nil {
+				return fmt.Errorf("Moderation reported request error: %v", err)
+			}
+			queue = append(queue, comments...)
+		}
+
+		slices.SortFunc(queue, compareQueued)
+		if int64(len(queue)) > limit {
+			queue = queue[:limit]
+		}
+
+		if len(queue) == 0 {
+			return nil
+		}
+
+		// the counts shown are those of the page only
+		ids := make([]bson.ObjectID, 0, len(queue))
+		for _, comment := range queue {
+			ids = append(ids, comment.ID)
+		}
+		counts, err := reportCounts(ctx, ids)
+		if err != nil {
+			return fmt.Errorf("Moderation report request error: %v", err)
+		}
+
+		reports := make(map[bson.ObjectID]RelationCount, len(counts))
+		for _, count := range counts {
+			reports[count.ObjectId] = count
+		}
+
+		for _, comment := range queue {
+			s.Response.Append(comment)
+			if count, ok := reports[comment.ID]; ok {
+				s.Response.Append(count)
+			}
+		}
+		return nil
+	}
+}
+
+// ModerateComment applies the "action" query parameter (approve, hide or
+// remove) to the comment of the idPath path value and resolves its reports.
+func ModerateComment(idPath string) HandlerFunc[Comment] {
+	return func(s *Session[Comment]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Moderation Session missing Response structure")
+		}
+
+		session, err := s.RequireModerator()
+		if err != nil {
+			return err
+		}
+
+		commentId, err := bson.ObjectIDFromHex(s.Request.PathValue(idPath))
+		if err != nil {
+			return NewStatusString("invalid id from path", http.StatusBadRequest)
+		}
+
+		state, ok := moderationStates[s.URLQuery().Get("action")]
+		if !ok {
+			return NewStatusString("invalid moderation action", http.StatusBadRequest)
+		}
+
+		ctx := s.Request.Context()
+		comments, err := km.Detect[Comment](km.Fld("ID").Eq(commentId)).Limit(1).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("Moderation request error: %v", err)
+		}
+
+		if len(comments) == 0 {
+			return NewStatusString("comment not found", http.StatusNotFound)
+		}
+
+		comment := comments[0]
+		previous := comment.CommentState()
+		update := commentModeration{State: state, ModeratedBy: session.UserName, ModeratedAt: time.Now().UTC()}
+		update.ID = comment.ID
+		if err := km.Record(ctx, &update); err != nil {
+			return fmt.Errorf("Moderation record error: %v", err)
+		}
+		comment.State = state
+
+		// the comment count of the root only covers visible comments
+		if previous != state && (previous == CommentVisible || state == CommentVisible) {
+			stat := PageStat{}
+			stat.ID = comment.Root
+			if state == CommentVisible {
+				stat.IncrCommentCount()
+			} else {
+				stat.DecrCommentCount()
+			}
+			if err := km.Record(ctx, &stat); err != nil {
+				log.Printf("Moderation: failed to update page comment stat %v", err)
+			}
+			invalidateFeed(ctx, cacheFeedTag, cacheRootTag(comment.Root))
+		}
+
+		creditComment(ctx, comment)
+
+		// a held comment notifies once approved
+		if err := NotifyComment(ctx, comment); err != nil {
+			log.Printf("Moderation: failed to notify %v", err)
+		}
+
+		if err := resolveReports(ctx, comment.ID); err != nil {
+			return err
+		}
+
+		s.Response.SetTargetID(comment.ID)
+		s.Response.Append(comment)
+		return nil
+	}
+}
+
+// resolveReports deactivates the active reports of a moderated comment.
+func resolveReports(ctx context.Context, commentId bson.ObjectID) error {
+	reports, err := km.Detect[UserToCommentLink](
+		km.Fld("objid").Eq(commentId),
+		km.Fld("relation").Eq(RelationReported),
+		km.Fld("state").Eq(RelationActive),
+	).Limit(MAX_MODERATION_QUEUE).PullAll(ctx)
+	if err != nil {
+		return fmt.Errorf("Moderation report request error: %v", err)
+	}
+
+	for i := range reports {
+		reports[i].State = RelationInactive
+		if err := km.Record(ctx, &reports[i]); err != nil {
+			log.Printf("Moderation: failed to resolve report %s %v", reports[i].ID.Hex(), err)
+		}
+	}
+	return nil
+}
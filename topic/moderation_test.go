@@ -0,0 +1,59 @@
+package topic
+
+import (
+	"slices"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestCommentVisibility(t *testing.T) {
+	cases := []struct {
+		state     string
+		public    bool
+		author    bool
+		moderator bool
+	}{
+		{"", true, true, true},
+		{CommentVisible, true, true, true},
+		{CommentPending, false, true, true},
+		{CommentHidden, false, true, true},
+		{CommentRemoved, false, false, true},
+	}
+
+	for _, c := range cases {
+		comment := Comment{UserName: "ada", State: c.state}
+		if comment.VisibleTo("", false) != c.public {
+			t.Errorf("%q: expected public visibility %v", c.state, c.public)
+		}
+		if comment.VisibleTo("ada", false) != c.author {
+			t.Errorf("%q: expected author visibility %v", c.state, c.author)
+		}
+		if comment.VisibleTo("bob", true) != c.moderator {
+			t.Errorf("%q: expected moderator visibility %v", c.state, c.moderator)
+		}
+	}
+
+	report, ok := LookupRelation(KindUser, KindComment, RelationReported)
+	if !ok || !report.CanTransition("", RelationActive) {
+		t.Errorf("expected the report relation to be registered")
+	}
+	if isCommentVote(RelationReported) {
+		t.Errorf("expected a report not to replace a vote")
+	}
+}
+
+func TestCompareQueued(t *testing.T) {
+	older := Comment{Moment: "2024-03-15 11:59"}
+	older.ID = bson.NewObjectID()
+	newer := Comment{Moment: "2024-03-15 12:00"}
+	newer.ID = bson.NewObjectID()
+	tied := Comment{Moment: newer.Moment}
+	tied.ID = bson.NewObjectID()
+
+	queue := []Comment{tied, newer, older}
+	slices.SortFunc(queue, compareQueued)
+	if queue[0].ID != older.ID || queue[1].ID != newer.ID || queue[2].ID != tied.ID {
+		t.Errorf("expected the queue oldest first by moment, then by ID, got %+v", queue)
+	}
+}
@@ -0,0 +1,328 @@
+package topic
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_MENTIONS = 10
+var MAX_NOTIFICATIONS int64 = 100
+
+// MAX_UNREAD_COUNT caps the unread count of an inbox; clients show it as "99+".
+var MAX_UNREAD_COUNT int64 = 99
+
+const (
+	NotifyMention = "mention"
+	NotifyReply   = "reply"
+)
+
+// mentionPattern matches @username at the start of the text or after a
+// character that cannot be part of a name or an email address.
+var mentionPattern = regexp.MustCompile(`(?:^|[^\w@.])@([\w][\w.-]{0,63})`)
+
+// Notification tells a user that a comment replied to or mentioned them.
+type Notification struct {
+	km.BaseModel `bson:",inline" kosmos:"notification"`
+	Recipient    string        `json:"-" bson:"recipient"`
+	Kind         string        `json:"kind" bson:"kind"`
+	Actor        string        `json:"actor" bson:"actor"`
+	CommentId    bson.ObjectID `json:"commentid" bson:"comment_id"`
+	Root         bson.ObjectID `json:"root" bson:"root"`
+	Read         bool          `json:"read" bson:"read"`
+	EventAt      time.Time     `json:"eventat" bson:"event_at"`
+	TtlStart     time.Time     `json:"-" bson:"ttl_start,omitempty"`
+}
+
+// SelfScope notifies a recipient once per comment.
+func (n Notification) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		km.Fld("Recipient").Eq(n.Recipient),
+		km.Fld("CommentId").Eq(n.CommentId),
+	)
+}
+
+// notificationRead records only the read fields of a notification; read
+// notifications expire from their TtlStart.
+type notificationRead struct {
+	km.BaseModel `bson:",inline" kosmos:"notification"`
+	Read         bool      `bson:"read"`
+	TtlStart     time.Time `bson:"ttl_start"`
+}
+
+// ParseMentions returns the distinct user names mentioned in the content, in
+// order of appearance, at most MAX_MENTIONS.
+func ParseMentions(content string) []string {
+	var retval []string
+	seen := make(map[string]bool)
+	for _, match := range mentionPattern.FindAllStringSubmatch(content, -1) {
+		name := strings.TrimRight(match[1], ".-")
+		if name == "" || seen[name] {
+			continue
+		}
+
+		seen[name] = true
+		retval = append(retval, name)
+		if len(retval) >= MAX_MENTIONS {
+			break
+		}
+	}
+	return retval
+}
+
+// commentNotifications lists the notifications of a comment: a reply to the
+// author of the parent and a mention to every other mentioned user. Nobody is
+// notified of their own comment.
+func commentNotifications(comment Comment, parentAuthor string) []Notification {
+	var retval []Notification
+	notified := map[string]bool{comment.UserName: true, "": true}
+	add := func(recipient string, kind string) {
+		if notified[recipient] {
+			return
+		}
+
+		notified[recipient] = true
+		retval = append(retval, Notification{
+			Recipient: recipient,
+			Kind:      kind,
+			Actor:     comment.UserName,
+			CommentId: comment.ID,
+			Root:      comment.Root,
+			EventAt:   comment.EventAt,
+		})
+	}
+
+	add(parentAuthor, NotifyReply)
+	for _, name := range ParseMentions(comment.Content) {
+		add(name, NotifyMention)
+	}
+	return retval
+}
+
+// knownUsers keeps the names of users known to the site, i.e. with an
+// AuthorStat, so that a mention of any name does not create an inbox.
+func knownUsers(ctx context.Context, names []string) (map[string]bool, error) {
+	retval := make(map[string]bool, len(names))
+	if len(names) == 0 {
+		return retval, nil
+	}
+
+	stats, err := km.Detect[AuthorStat](km.Fld("Name").In(names)).Limit(int64(len(names))).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, stat := range stats {
+		retval[stat.Name] = true
+	}
+	return retval, nil
+}
+
+// NotifyComment records the notifications of a visible comment to known
+// users. Recording again, e.g. after an edit or an approval, only adds newly
+// mentioned users.
+func NotifyComment(ctx context.Context, comment Comment) error {
+	if comment.CommentState() != CommentVisible {
+		return nil
+	}
+
+	parentAuthor := ""
+	if !comment.Parent.IsZero() {
+		parents, err := km.Detect[Comment](km.Fld("ID").Eq(comment.Parent)).Limit(1).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("NotifyComment parent: %v", err)
+		}
+		if len(parents) > 0 {
+			parentAuthor = parents[0].UserName
+		}
+	}
+
+	notifications := commentNotifications(comment, parentAuthor)
+	recipients := make([]string, 0, len(notifications))
+	for _, notification := range notifications {
+		recipients = append(recipients, notification.Recipient)
+	}
+
+	known, err := knownUsers(ctx, recipients)
+	if err != nil {
+		return fmt.Errorf("NotifyComment recipient request error: %v", err)
+	}
+
+	for _, notification := range notifications {
+		if !known[notification.Recipient] {
+			continue
+		}
+
+		existing, err := km.Detect[Notification](
+			km.Fld("recipient").Eq(notification.Recipient),
+			km.Fld("comment_id").Eq(notification.CommentId),
+		).Limit(1).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("NotifyComment request error: %v", err)
+		}
+
+		if len(existing) > 0 {
+			continue
+		}
+
+		if err := km.Record(ctx, &notification); err != nil {
+			return fmt.Errorf("NotifyComment record error: %v", err)
+		}
+	}
+	return nil
+}
+
+// NotifyInBody notifies the recipients of the comment in the body. It is
+// chained after the handler recording a new comment, outside of the write.
+func NotifyInBody() HandlerFunc[Comment] {
+	return func(s *Session[Comment]) error {
+		if s.InBody.ID.IsZero() {
+			return nil
+		}
+
+		if err := NotifyComment(s.Request.Context(), s.InBody); err != nil {
+			log.Printf("Notification: failed to notify %v", err)
+		}
+		return nil
+	}
+}
+
+func unreadCount(ctx context.Context, recipient string) (int, error) {
+	unread, err := km.Detect[Notification](
+		km.Fld("recipient").Eq(recipient),
+		km.Fld("read").Eq(false),
+	).Limit(MAX_UNREAD_COUNT + 1).PullAll(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return len(unread), nil
+}
+
+// PullNotifications returns the newest notifications of the authenticated
+// user with the comments they refer to and the unread count. The "unread"
+// query parameter restricts them to unread notifications.
+func PullNotifications(limit int64) HandlerFunc[Notification] {
+	return func(s *Session[Notification]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Notification Session missing Response structure")
+		}
+
+		session, err := s.RequireUser()
+		if err != nil {
+			return err
+		}
+
+		predicates := []expression.QueryFieldPredicate{
+			km.Fld("recipient").Eq(session.UserName),
+		}
+		if s.URLQuery().Get("unread") == "true" {
+			predicates = append(predicates, km.Fld("read").Eq(false))
+		}
+
+		ctx := s.Request.Context()
+		notifications, err := km.Detect[Notification](predicates...).SortLatest().Limit(min(limit, MAX_NOTIFICATIONS)).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("Notification request error: %v", err)
+		}
+
+		commentIds := make([]bson.ObjectID, 0, len(notifications))
+		for _, notification := range notifications {
+			s.Response.Append(notification)
+			commentIds = append(commentIds, notification.CommentId)
+		}
+
+		if len(commentIds) > 0 {
+			userName, moderator := s.Viewer()
+			comments, err := km.Detect[Comment](
+				append(commentsVisibleTo(userName, moderator), km.Fld("ID").In(commentIds))...,
+			).Limit(int64(len(commentIds))).PullAll(ctx)
+			if err != nil {
+				return fmt.Errorf("Notification comment request error: %v", err)
+			}
+
+			for _, comment := range comments {
+				if err := comment.Sanitize(s.RequestContext); err != nil {
+					return err
+				}
+				s.Response.Append(comment)
+			}
+		}
+
+		return appendUnreadCount(ctx, s.Response, session.UserName)
+	}
+}
+
+// MarkNotificationsRead marks the notifications of the "id" query parameters
+// as read, or every unread notification of the user when none is given.
+func MarkNotificationsRead() HandlerFunc[Notification] {
+	return func(s *Session[Notification]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Notification Session missing Response structure")
+		}
+
+		session, err := s.RequireUser()
+		if err != nil {
+			return err
+		}
+
+		predicates := []expression.QueryFieldPredicate{
+			km.Fld("recipient").Eq(session.UserName),
+			km.Fld("read").Eq(false),
+		}
+
+		if values := s.URLQuery()["id"]; len(values) > 0 {
+			ids, err := convertStringToIDs(values)
+			if err != nil {
+				return NewStatusString("invalid id from query", http.StatusBadRequest)
+			}
+			predicates = append(predicates, km.Fld("ID").In(ids))
+		}
+
+		// marked notifications leave the query: read batches until none
+		// remains, or a batch fails to be marked
+		ctx := s.Request.Context()
+		now := time.Now().UTC()
+		for {
+			unread, err := km.Detect[Notification](predicates...).Limit(MAX_NOTIFICATIONS).PullAll(ctx)
+			if err != nil {
+				return fmt.Errorf("Notification request error: %v", err)
+			}
+
+			failed := false
+			for _, notification := range unread {
+				update := notificationRead{Read: true, TtlStart: now}
+				update.ID = notification.ID
+				if err := km.Record(ctx, &update); err != nil {
+					log.Printf("Notification: failed to mark %s read %v", notification.ID.Hex(), err)
+					failed = true
+				}
+			}
+
+			if failed || int64(len(unread)) < MAX_NOTIFICATIONS {
+				break
+			}
+		}
+
+		return appendUnreadCount(ctx, s.Response, session.UserName)
+	}
+}
+
+func appendUnreadCount(ctx context.Context, response Response, recipient string) error {
+	count, err := unreadCount(ctx, recipient)
+	if err != nil {
+		return fmt.Errorf("Notification unread request error: %v", err)
+	}
+
+	if inbox, ok := response.(*NotificationResponse); ok {
+		inbox.Unread = count
+	}
+	return nil
+}
@@ -0,0 +1,28 @@
+package topic
+
+import (
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestParseMentions(t *testing.T) {
+	mentions := ParseMentions("@ada thanks, cc @bob.smith and @ada again. Mail me at cy@example.com or @dee.")
+	if strings.Join(mentions, ",") != "ada,bob.smith,dee" {
+		t.Errorf("unexpected mentions %v", mentions)
+	}
+
+	comment := Comment{UserName: "bob.smith", Content: "@ada @bob.smith @eve", Root: bson.NewObjectID()}
+	comment.ID = bson.NewObjectID()
+	notifications := commentNotifications(comment, "eve")
+	if len(notifications) != 2 {
+		t.Fatalf("unexpected notifications %+v", notifications)
+	}
+	if notifications[0].Recipient != "eve" || notifications[0].Kind != NotifyReply {
+		t.Errorf("expected the parent author to be notified of a reply, got %+v", notifications[0])
+	}
+	if notifications[1].Recipient != "ada" || notifications[1].Kind != NotifyMention || notifications[1].Actor != "bob.smith" {
+		t.Errorf("expected ada to be notified of a mention, got %+v", notifications[1])
+	}
+}
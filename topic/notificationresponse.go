@@ -0,0 +1,28 @@
+package topic
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type NotificationResponse struct {
+	EntangledResponse
+	NotificationData []Notification `xml:"-" json:"NotificationData,omitempty" bson:"-" `
+	Unread           int            `xml:"-" json:"Unread" bson:"-" `
+}
+
+func NewNotificationResponse() Response {
+	return &NotificationResponse{}
+}
+
+func (nr *NotificationResponse) Append(data any) bson.ObjectID {
+	switch response := data.(type) {
+	case Notification:
+		nr.NotificationData = append(nr.NotificationData, response)
+		return response.ID
+	case *Notification:
+		nr.NotificationData = append(nr.NotificationData, *response)
+		return response.ID
+	}
+
+	return nr.EntangledResponse.Append(data)
+}
@@ -0,0 +1,289 @@
+package topic
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// Query-string filter language. A request may carry terms of the form
+//
+//	?<name>=<value>            exact match (same as <name>.eq)
+//	?<name>.<op>=<value>       eq, ne, in, gt, lt, prefix, exists
+//
+// Only names present in the resource's QueryFields allow-list are considered;
+// every other parameter is left for the rest of the handler chain.
+
+var MAX_QUERY_IN_SIZE = 100
+var MAX_QUERY_VALUE_LENGTH = 255
+
+type QueryOp string
+
+const (
+	QueryEq     QueryOp = "eq"
+	QueryNe     QueryOp = "ne"
+	QueryIn     QueryOp = "in"
+	QueryGt     QueryOp = "gt"
+	QueryLt     QueryOp = "lt"
+	QueryPrefix QueryOp = "prefix"
+	QueryExists QueryOp = "exists"
+)
+
+type QueryKind int
+
+const (
+	QueryString QueryKind = iota
+	QueryID
+	QueryTime
+	QueryBool
+	QueryNumber
+)
+
+func (k QueryKind) defaultOps() []QueryOp {
+	switch k {
+	case QueryString:
+		return []QueryOp{QueryEq, QueryNe, QueryIn, QueryPrefix, QueryExists}
+	case QueryID:
+		return []QueryOp{QueryEq, QueryNe, QueryIn, QueryExists}
+	case QueryTime:
+		return []QueryOp{QueryEq, QueryGt, QueryLt, QueryExists}
+	case QueryBool:
+		return []QueryOp{QueryEq, QueryNe, QueryExists}
+	case QueryNumber:
+		return []QueryOp{QueryEq, QueryNe, QueryIn, QueryGt, QueryLt, QueryExists}
+	}
+	return nil
+}
+
+// QueryField whitelists one query parameter and maps it onto a stored field.
+type QueryField struct {
+	Name  string    // query parameter name
+	Field string    // kosmos field name
+	Kind  QueryKind // how values are parsed
+	Ops   []QueryOp // allowed operators; nil means the defaults for Kind
+}
+
+func (q QueryField) allows(op QueryOp) bool {
+	ops := q.Ops
+	if ops == nil {
+		ops = q.Kind.defaultOps()
+	}
+	return slices.Contains(ops, op)
+}
+
+type QueryFields []QueryField
+
+func (qf QueryFields) lookup(name string) (QueryField, bool) {
+	for _, field := range qf {
+		if field.Name == name {
+			return field, true
+		}
+	}
+	return QueryField{}, false
+}
+
+var PageQueryFields = QueryFields{
+	{Name: "root", Field: "Root", Kind: QueryID},
+	{Name: "author", Field: "Author", Kind: QueryString},
+	{Name: "title", Field: "Title", Kind: QueryString},
+	{Name: "linkname", Field: "LinkName", Kind: QueryString},
+	{Name: "category", Field: "infos.category", Kind: QueryString},
+	{Name: "tags", Field: "infos.tags", Kind: QueryString},
+	{Name: "source", Field: "infos.source", Kind: QueryString},
+	{Name: "marketimpact", Field: "infos.has_market_impact", Kind: QueryBool},
+	{Name: "eventat", Field: "EventAt", Kind: QueryTime},
+	{Name: "previousversion", Field: "PreviousVersion", Kind: QueryID},
+}
+
+var CommentQueryFields = QueryFields{
+	{Name: "root", Field: "Root", Kind: QueryID},
+	{Name: "parent", Field: "Parent", Kind: QueryID},
+	{Name: "username", Field: "UserName", Kind: QueryString},
+	{Name: "sourceid", Field: "infos.sourceid", Kind: QueryID},
+	{Name: "category", Field: "infos.category", Kind: QueryString},
+	{Name: "tags", Field: "infos.tags", Kind: QueryString},
+	{Name: "eventat", Field: "EventAt", Kind: QueryTime},
+}
+
+var PageStatQueryFields = QueryFields{
+	{Name: "title", Field: "Title", Kind: QueryString},
+	{Name: "authors", Field: "Authors", Kind: QueryString, Ops: []QueryOp{QueryEq, QueryIn}},
+	{Name: "commentcount", Field: "CommentCount", Kind: QueryNumber},
+}
+
+var RelationQueryFields = QueryFields{
+	{Name: "objid", Field: "ObjectId", Kind: QueryID, Ops: []QueryOp{QueryEq, QueryIn}},
+	{Name: "relation", Field: "Relation", Kind: QueryString, Ops: []QueryOp{QueryEq, QueryNe, QueryIn}},
+	{Name: "state", Field: "State", Kind: QueryString, Ops: []QueryOp{QueryEq, QueryNe, QueryIn, QueryExists}},
+}
+
+type queryTerm struct {
+	Field  QueryField
+	Op     QueryOp
+	Values []any
+}
+
+func (t queryTerm) predicate() expression.QueryFieldPredicate {
+	fld := kosmos.Fld(t.Field.Field)
+	switch t.Op {
+	case QueryNe:
+		return fld.Ne(t.Values[0])
+	case QueryIn:
+		return fld.In(t.Values)
+	case QueryGt:
+		return fld.Gt(t.Values[0])
+	case QueryLt:
+		return fld.Lt(t.Values[0])
+	case QueryPrefix:
+		return fld.Regex("^" + regexp.QuoteMeta(t.Values[0].(string)))
+	case QueryExists:
+		return fld.Exists(t.Values[0].(bool))
+	}
+	return fld.Eq(t.Values[0])
+}
+
+// parseQueryTerms extracts the whitelisted terms from values. Terms are
+// returned in key order so the resulting query is deterministic.
+func (qf QueryFields) parseQueryTerms(values url.Values) ([]queryTerm, error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var terms []queryTerm
+	for _, key := range keys {
+		name, opStr, hasOp := strings.Cut(key, ".")
+		field, ok := qf.lookup(name)
+		if !ok {
+			continue
+		}
+
+		op := QueryEq
+		if hasOp {
+			op = QueryOp(opStr)
+		}
+
+		if !field.allows(op) {
+			return nil, NewStatusString(fmt.Sprintf("query operator %q not allowed on %q", op, name), http.StatusBadRequest)
+		}
+
+		for _, raw := range values[key] {
+			term, err := parseQueryTerm(field, op, raw)
+			if err != nil {
+				return nil, err
+			}
+			terms = append(terms, term)
+		}
+	}
+	return terms, nil
+}
+
+func parseQueryTerm(field QueryField, op QueryOp, raw string) (queryTerm, error) {
+	term := queryTerm{Field: field, Op: op}
+
+	if len(raw) > MAX_QUERY_VALUE_LENGTH {
+		return term, NewStatusString(fmt.Sprintf("query value too long for %q", field.Name), http.StatusBadRequest)
+	}
+
+	switch op {
+	case QueryExists:
+		exists, err := strconv.ParseBool(raw)
+		if err != nil {
+			return term, NewStatusString(fmt.Sprintf("invalid exists value for %q", field.Name), http.StatusBadRequest)
+		}
+		term.Values = []any{exists}
+		return term, nil
+	case QueryPrefix:
+		if raw == "" {
+			return term, NewStatusString(fmt.Sprintf("empty prefix for %q", field.Name), http.StatusBadRequest)
+		}
+		term.Values = []any{raw}
+		return term, nil
+	case QueryIn:
+		parts := strings.Split(raw, ",")
+		if len(parts) > MAX_QUERY_IN_SIZE {
+			return term, NewStatusString(fmt.Sprintf("too many values for %q", field.Name), http.StatusBadRequest)
+		}
+		for _, part := range parts {
+			value, err := parseQueryValue(field, part)
+			if err != nil {
+				return term, err
+			}
+			term.Values = append(term.Values, value)
+		}
+		return term, nil
+	}
+
+	value, err := parseQueryValue(field, raw)
+	if err != nil {
+		return term, err
+	}
+	term.Values = []any{value}
+	return term, nil
+}
+
+func parseQueryValue(field QueryField, raw string) (any, error) {
+	switch field.Kind {
+	case QueryID:
+		id, err := bson.ObjectIDFromHex(raw)
+		if err != nil {
+			return nil, NewStatusString(fmt.Sprintf("invalid id for %q", field.Name), http.StatusBadRequest)
+		}
+		return id, nil
+	case QueryTime:
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, NewStatusString(fmt.Sprintf("invalid time for %q", field.Name), http.StatusBadRequest)
+		}
+		return t.UTC(), nil
+	case QueryBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return nil, NewStatusString(fmt.Sprintf("invalid bool for %q", field.Name), http.StatusBadRequest)
+		}
+		return b, nil
+	case QueryNumber:
+		n, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return nil, NewStatusString(fmt.Sprintf("invalid number for %q", field.Name), http.StatusBadRequest)
+		}
+		return n, nil
+	}
+	return raw, nil
+}
+
+// ByQuery filters on the whitelisted query-string terms of fields. All terms
+// must match; a request without any term leaves the query unconstrained.
+func ByQuery(fields QueryFields) Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		terms, err := fields.parseQueryTerms(s.URLQuery())
+		if err != nil {
+			return nil, err
+		}
+
+		switch len(terms) {
+		case 0:
+			return nil, nil
+		case 1:
+			pred := terms[0].predicate()
+			return &pred, nil
+		}
+
+		predicates := make([]expression.QueryFieldPredicate, 0, len(terms))
+		for _, term := range terms {
+			predicates = append(predicates, term.predicate())
+		}
+		pred := kosmos.And(predicates...)
+		return &pred, nil
+	}
+}
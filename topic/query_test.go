@@ -0,0 +1,222 @@
+package topic
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestParseQueryTerms(t *testing.T) {
+	id := bson.NewObjectID()
+	values := url.Values{
+		"root":         {id.Hex()},
+		"title.prefix": {"Hello"},
+		"eventat.gt":   {"2024-01-01T10:00:00Z"},
+		"tags.in":      {"a,b,c"},
+		"unrelated":    {"ignored"},
+	}
+
+	terms, err := PageQueryFields.parseQueryTerms(values)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(terms) != 4 {
+		t.Fatalf("expected 4 terms, got %d", len(terms))
+	}
+
+	// terms are sorted by key
+	if terms[0].Field.Name != "eventat" || terms[0].Op != QueryGt {
+		t.Errorf("expected eventat.gt first, got %s.%s", terms[0].Field.Name, terms[0].Op)
+	}
+	if at, ok := terms[0].Values[0].(time.Time); !ok || !at.Equal(time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected parsed time, got %v", terms[0].Values[0])
+	}
+
+	if terms[1].Field.Name != "root" || terms[1].Values[0] != id {
+		t.Errorf("expected root id term, got %v", terms[1])
+	}
+
+	if terms[2].Op != QueryIn || len(terms[2].Values) != 3 {
+		t.Errorf("expected tags.in with 3 values, got %v", terms[2])
+	}
+
+	if terms[3].Op != QueryPrefix || terms[3].Values[0] != "Hello" {
+		t.Errorf("expected title prefix term, got %v", terms[3])
+	}
+}
+
+func TestParseQueryTermsErrors(t *testing.T) {
+	cases := []url.Values{
+		{"root": {"not-an-id"}},
+		{"eventat.gt": {"yesterday"}},
+		{"eventat.prefix": {"2024"}},
+		{"title.regex": {".*"}},
+		{"marketimpact": {"maybe"}},
+		{"title.exists": {"perhaps"}},
+		{"title.prefix": {""}},
+	}
+
+	for _, values := range cases {
+		_, err := PageQueryFields.parseQueryTerms(values)
+		if err == nil {
+			t.Errorf("expected error for %v", values)
+			continue
+		}
+		status, ok := err.(ErrorResponse)
+		if !ok || status.ErrorCode() != 400 {
+			t.Errorf("expected 400 status for %v, got %v", values, err)
+		}
+	}
+}
+
+func TestByQuery(t *testing.T) {
+	req := httptest.NewRequest("GET", "/pages?author=bob&commentcount.gt=3", nil)
+	ctx := RequestContext{Request: req}
+
+	pred, err := ByQuery(PageQueryFields)(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if pred == nil {
+		t.Errorf("expected predicate for author term")
+	}
+
+	req = httptest.NewRequest("GET", "/pages?other=1", nil)
+	pred, err = ByQuery(PageQueryFields)(RequestContext{Request: req})
+	if err != nil || pred != nil {
+		t.Errorf("expected no predicate without whitelisted terms, got %v %v", pred, err)
+	}
+}
+
+func TestFilterCombinators(t *testing.T) {
+	req := httptest.NewRequest("GET", "/pages?tag=x", nil)
+	ctx := RequestContext{Request: req}
+
+	missing := Fld("Author").ByQueryValues("author")
+	tagged := Fld("infos.tags").ByQueryValues("tag")
+
+	if _, err := missing(ctx); !IsMissingInput(err) {
+		t.Errorf("expected missing input error, got %v", err)
+	}
+
+	pred, err := missing.Optional()(ctx)
+	if err != nil || pred != nil {
+		t.Errorf("expected optional filter to be skipped, got %v %v", pred, err)
+	}
+
+	pred, err = missing.Optional().Or(tagged)(ctx)
+	if err != nil || pred == nil {
+		t.Errorf("expected remaining alternative to apply, got %v %v", pred, err)
+	}
+
+	if _, err := missing.Or(tagged)(ctx); err == nil {
+		t.Errorf("expected required filter to fail the combination")
+	}
+
+	pred, err = AllOf(missing.Optional(), Fld("Root").ByQueryValues("root").Optional())(ctx)
+	if err != nil || pred != nil {
+		t.Errorf("expected empty AllOf to be unconstrained, got %v %v", pred, err)
+	}
+
+	pred, err = AnyOf(missing.Optional(), Fld("Root").ByQueryValues("root").Optional())(ctx)
+	if err != nil || pred != nil {
+		t.Errorf("expected AnyOf without alternatives to be skipped, got %v %v", pred, err)
+	}
+
+	pred, err = Not(AnyOf(missing.Optional()))(ctx)
+	if err != nil || pred != nil {
+		t.Errorf("expected negated skipped AnyOf to be skipped, got %v %v", pred, err)
+	}
+
+	pred, err = Not(AllOf(missing.Optional(), tagged))(ctx)
+	if err != nil || pred == nil {
+		t.Errorf("expected negated AllOf to keep its remaining filter, got %v %v", pred, err)
+	}
+
+	pred, err = Not(missing.Optional())(ctx)
+	if err != nil || pred != nil {
+		t.Errorf("expected negated skipped filter to be unconstrained, got %v %v", pred, err)
+	}
+
+	pred, err = Not(tagged)(ctx)
+	if err != nil || pred == nil {
+		t.Errorf("expected negated predicate, got %v %v", pred, err)
+	}
+}
+
+func TestParseTimeBound(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	cases := map[string]time.Time{
+		"now":                  now,
+		"-24h":                 now.Add(-24 * time.Hour),
+		"-7d":                  now.AddDate(0, 0, -7),
+		"-2w":                  now.AddDate(0, 0, -14),
+		"2024-01-01T10:00:00Z": time.Date(2024, 1, 1, 10, 0, 0, 0, time.UTC),
+		"2024-02-01":           time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	for value, expected := range cases {
+		got, err := ParseTimeBound(value, now)
+		if err != nil {
+			t.Errorf("ParseTimeBound(%q) error: %v", value, err)
+			continue
+		}
+		if !got.Equal(expected) {
+			t.Errorf("ParseTimeBound(%q) = %v, want %v", value, got, expected)
+		}
+	}
+
+	for _, value := range []string{"yesterday", "-xd", "2024-13-01", "-9999999999999d", "+99999999999999w"} {
+		if _, err := ParseTimeBound(value, now); err == nil {
+			t.Errorf("ParseTimeBound(%q) expected error", value)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/pages?since=-9999999999999d", nil)
+	_, err := Fld("EventAt").ByTimeWindow("since", "until")(RequestContext{Request: req})
+	var status *StatusResponse
+	if !errors.As(err, &status) || status.StatusCode != http.StatusBadRequest {
+		t.Errorf("expected an out of range bound to be a bad request, got %v", err)
+	}
+}
+
+func TestByTimeWindow(t *testing.T) {
+	cases := map[string]bool{
+		"/pages?since=-7d":                         true,
+		"/pages?since=2024-01-01&until=2024-02-01": true,
+		"/pages?until=2024-02-01":                  true,
+		"/pages?since=2024-02-01&until=2024-01-01": false,
+		"/pages?since=2000-01-01&until=2024-01-01": false,
+		"/pages?since=soon":                        false,
+	}
+
+	for target, valid := range cases {
+		ctx := RequestContext{Request: httptest.NewRequest("GET", target, nil)}
+		pred, err := ByEventWindow()(ctx)
+		if valid && (err != nil || pred == nil) {
+			t.Errorf("%s: expected predicate, got %v %v", target, pred, err)
+		}
+		if !valid && err == nil {
+			t.Errorf("%s: expected error", target)
+		}
+	}
+
+	ctx := RequestContext{Request: httptest.NewRequest("GET", "/pages", nil)}
+	if _, err := ByEventWindow()(ctx); !IsMissingInput(err) {
+		t.Errorf("expected missing input without bounds, got %v", err)
+	}
+
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	until := now.AddDate(0, -1, 0)
+	since, _, err := timeBounds(time.Time{}, until, now)
+	if err != nil || !since.Equal(until.Add(-MAX_TIME_WINDOW)) {
+		t.Errorf("expected an until-only window to be bounded, got %v %v", since, err)
+	}
+}
@@ -0,0 +1,521 @@
+package topic
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_LIST_NAME_LENGTH = 127
+var MAX_LISTS_PER_USER int64 = 100
+var MAX_LIST_ITEMS int64 = 500
+
+// Items are ordered by fractional positions so that a move only rewrites the
+// moved item. Positions are renumbered LIST_POSITION_STEP apart once two
+// neighbours get closer than MIN_LIST_POSITION_GAP.
+var LIST_POSITION_STEP = 1024.0
+var MIN_LIST_POSITION_GAP = 1e-6
+
+const (
+	ListPrivate = "private"
+	ListPublic  = "public"
+)
+
+// Placement of an item (the After field): at the end, at the front, or after
+// the root with the given hex ID.
+const (
+	ListPlaceLast  = ""
+	ListPlaceFirst = "first"
+)
+
+type ReadingList struct {
+	km.BaseModel `bson:",inline" kosmos:"reading_list"`
+	XMLName      xml.Name      `xml:"reading_list" json:"-" bson:"-"`
+	OwnerId      bson.ObjectID `xml:"-" json:"-" bson:"owner_id"`
+	Owner        string        `xml:"owner" json:"owner" bson:"owner"`
+	Name         string        `xml:"name" json:"name" bson:"name"`
+	Visibility   string        `xml:"visibility" json:"visibility" bson:"visibility"`
+	Deleted      bool          `xml:"-" json:"-" bson:"deleted"`
+	TtlStart     time.Time     `xml:"-" json:"-" bson:"ttl_start,omitempty"`
+}
+
+type ReadingListItem struct {
+	km.BaseModel `bson:",inline" kosmos:"reading_list_item"`
+	XMLName      xml.Name      `xml:"reading_list_item" json:"-" bson:"-"`
+	ListId       bson.ObjectID `xml:"listid" json:"listid" bson:"list_id"`
+	Root         bson.ObjectID `xml:"root" json:"root" bson:"root"`
+	Position     float64       `xml:"position" json:"position" bson:"position"`
+	Removed      bool          `xml:"-" json:"-" bson:"removed"`
+	TtlStart     *time.Time    `xml:"-" json:"-" bson:"ttl_start"`
+	After        string        `xml:"-" json:"after,omitempty" bson:"-"`
+}
+
+func (i ReadingListItem) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		km.Fld("ListId").Eq(i.ListId),
+		km.Fld("Root").Eq(i.Root),
+	)
+}
+
+func (l ReadingList) VisibleTo(userId bson.ObjectID) bool {
+	if l.Deleted {
+		return false
+	}
+	return l.Visibility == ListPublic || (!userId.IsZero() && l.OwnerId == userId)
+}
+
+func validateReadingList(list *ReadingList) error {
+	list.Name = strings.TrimSpace(list.Name)
+	if list.Name == "" {
+		return NewStatusString("missing list name", http.StatusBadRequest)
+	}
+
+	if len(list.Name) > MAX_LIST_NAME_LENGTH {
+		return NewStatusString("list name too long", http.StatusBadRequest)
+	}
+
+	switch list.Visibility {
+	case "":
+		list.Visibility = ListPrivate
+	case ListPrivate, ListPublic:
+	default:
+		return NewStatusString("invalid list visibility", http.StatusBadRequest)
+	}
+	return nil
+}
+
+// placeItem computes the position of root placed as requested among items
+// (sorted by position). When the gap is exhausted the other items are
+// renumbered and returned so the caller can persist them.
+func placeItem(items []ReadingListItem, root bson.ObjectID, after string) (float64, []ReadingListItem, error) {
+	others := make([]ReadingListItem, 0, len(items))
+	for _, item := range items {
+		if item.Root != root {
+			others = append(others, item)
+		}
+	}
+
+	index := len(others) // insert before others[index]
+	switch after {
+	case ListPlaceLast:
+	case ListPlaceFirst:
+		index = 0
+	default:
+		afterRoot, err := bson.ObjectIDFromHex(after)
+		if err != nil {
+			return 0, nil, NewStatusString("invalid list placement", http.StatusBadRequest)
+		}
+		found := slices.IndexFunc(others, func(item ReadingListItem) bool {
+			return item.Root == afterRoot
+		})
+		if found < 0 {
+			return 0, nil, NewStatusString("list placement not found", http.StatusNotFound)
+		}
+		index = found + 1
+	}
+
+	position, ok := positionAt(others, index)
+	if ok {
+		return position, nil, nil
+	}
+
+	for i := range others {
+		others[i].Position = float64(i+1) * LIST_POSITION_STEP
+	}
+	position, _ = positionAt(others, index)
+	return position, others, nil
+}
+
+func positionAt(items []ReadingListItem, index int) (float64, bool) {
+	switch {
+	case len(items) == 0:
+		return LIST_POSITION_STEP, true
+	case index == 0:
+		return items[0].Position - LIST_POSITION_STEP, true
+	case index == len(items):
+		return items[len(items)-1].Position + LIST_POSITION_STEP, true
+	}
+
+	before, next := items[index-1].Position, items[index].Position
+	if next-before < MIN_LIST_POSITION_GAP {
+		return 0, false
+	}
+	return before + (next-before)/2, true
+}
+
+func sortListItems(items []ReadingListItem) {
+	slices.SortStableFunc(items, func(a, b ReadingListItem) int {
+		return cmp.Compare(a.Position, b.Position)
+	})
+}
+
+func loadReadingList(ctx context.Context, id bson.ObjectID) (*ReadingList, error) {
+	lists, err := km.Detect[ReadingList](
+		km.Fld("ID").Eq(id),
+		km.Fld("deleted").Ne(true),
+	).Limit(1).PullAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ReadingList request error: %v", err)
+	}
+
+	if len(lists) == 0 {
+		return nil, NewStatusString("list not found", http.StatusNotFound)
+	}
+	return &lists[0], nil
+}
+
+// loadOwnedList loads the list of the idPath path value owned by the user.
+// Lists of other users are reported as missing.
+func loadOwnedList(s *RequestContext, idPath string) (*ReadingList, error) {
+	session, err := s.RequireUser()
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := bson.ObjectIDFromHex(s.Request.PathValue(idPath))
+	if err != nil {
+		return nil, NewStatusString("invalid id from path", http.StatusBadRequest)
+	}
+
+	list, err := loadReadingList(s.Request.Context(), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if list.OwnerId != session.UserId {
+		return nil, NewStatusString("list not found", http.StatusNotFound)
+	}
+	return list, nil
+}
+
+func loadListItems(ctx context.Context, listId bson.ObjectID) ([]ReadingListItem, error) {
+	items, err := km.Detect[ReadingListItem](
+		km.Fld("ListId").Eq(listId),
+		km.Fld("removed").Ne(true),
+	).Limit(MAX_LIST_ITEMS).PullAll(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ReadingListItem request error: %v", err)
+	}
+
+	sortListItems(items)
+	return items, nil
+}
+
+func CreateReadingList() HandlerFunc[ReadingList] {
+	return func(s *Session[ReadingList]) error {
+		if s.Response == nil {
+			return fmt.Errorf("ReadingList Session missing Response structure")
+		}
+
+		session, err := s.RequireUser()
+		if err != nil {
+			return err
+		}
+
+		if err := s.DecodeBody(); err != nil {
+			return NewStatusError(err, http.StatusBadRequest)
+		}
+
+		ctx := s.Request.Context()
+		owned, err := km.Detect[ReadingList](
+			km.Fld("OwnerId").Eq(session.UserId),
+			km.Fld("deleted").Ne(true),
+		).Limit(MAX_LISTS_PER_USER).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("ReadingList request error: %v", err)
+		}
+
+		if int64(len(owned)) >= MAX_LISTS_PER_USER {
+			return NewStatusString("too many lists", http.StatusConflict)
+		}
+
+		list := ReadingList{
+			OwnerId:    session.UserId,
+			Owner:      session.UserName,
+			Name:       s.InBody.Name,
+			Visibility: s.InBody.Visibility,
+		}
+		list.ID = bson.NewObjectID()
+		if err := validateReadingList(&list); err != nil {
+			return err
+		}
+
+		if err := km.Record(ctx, &list); err != nil {
+			return fmt.Errorf("ReadingList record error: %v", err)
+		}
+
+		s.Response.Append(list)
+		return nil
+	}
+}
+
+// UpdateReadingList renames the list and/or changes its visibility.
+func UpdateReadingList(idPath string) HandlerFunc[ReadingList] {
+	return func(s *Session[ReadingList]) error {
+		if s.Response == nil {
+			return fmt.Errorf("ReadingList Session missing Response structure")
+		}
+
+		list, err := loadOwnedList(&s.RequestContext, idPath)
+		if err != nil {
+			return err
+		}
+
+		if err := s.DecodeBody(); err != nil {
+			return NewStatusError(err, http.StatusBadRequest)
+		}
+
+		if s.InBody.Name != "" {
+			list.Name = s.InBody.Name
+		}
+		if s.InBody.Visibility != "" {
+			list.Visibility = s.InBody.Visibility
+		}
+		if err := validateReadingList(list); err != nil {
+			return err
+		}
+
+		if err := km.Record(s.Request.Context(), list); err != nil {
+			return fmt.Errorf("ReadingList record error: %v", err)
+		}
+
+		s.Response.Append(*list)
+		return nil
+	}
+}
+
+// DeleteReadingList hides the list at once; the list expires through the
+// ttl_start TTL index.
+func DeleteReadingList(idPath string) HandlerFunc[ReadingList] {
+	return func(s *Session[ReadingList]) error {
+		list, err := loadOwnedList(&s.RequestContext, idPath)
+		if err != nil {
+			return err
+		}
+
+		list.Deleted = true
+		list.TtlStart = time.Now()
+		if err := km.Record(s.Request.Context(), list); err != nil {
+			return fmt.Errorf("ReadingList record error: %v", err)
+		}
+		return nil
+	}
+}
+
+// PutReadingListItem adds the root of the body to the list, or moves it when
+// already present, at the placement given by its After field.
+func PutReadingListItem(idPath string) HandlerFunc[ReadingListItem] {
+	return func(s *Session[ReadingListItem]) error {
+		if s.Response == nil {
+			return fmt.Errorf("ReadingList Session missing Response structure")
+		}
+
+		list, err := loadOwnedList(&s.RequestContext, idPath)
+		if err != nil {
+			return err
+		}
+
+		if err := s.DecodeBody(); err != nil {
+			return NewStatusError(err, http.StatusBadRequest)
+		}
+
+		if s.InBody.Root.IsZero() {
+			return NewStatusString("missing root", http.StatusBadRequest)
+		}
+
+		ctx := s.Request.Context()
+		items, err := loadListItems(ctx, list.ID)
+		if err != nil {
+			return err
+		}
+
+		present := slices.ContainsFunc(items, func(item ReadingListItem) bool {
+			return item.Root == s.InBody.Root
+		})
+		if !present {
+			if int64(len(items)) >= MAX_LIST_ITEMS {
+				return NewStatusString("list is full", http.StatusConflict)
+			}
+
+			top, err := TopPageByRoot(ctx, s.InBody.Root)
+			if err != nil {
+				return fmt.Errorf("ReadingList root request error: %v", err)
+			}
+			if top == nil {
+				return NewStatusString("root not found", http.StatusNotFound)
+			}
+		}
+
+		position, renumbered, err := placeItem(items, s.InBody.Root, s.InBody.After)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range renumbered {
+			if err := km.Record(ctx, &item); err != nil {
+				return fmt.Errorf("ReadingListItem record error: %v", err)
+			}
+		}
+
+		// a root added again after removal no longer expires
+		item := ReadingListItem{ListId: list.ID, Root: s.InBody.Root, Position: position, TtlStart: nil}
+		if err := km.Record(ctx, &item); err != nil {
+			return fmt.Errorf("ReadingListItem record error: %v", err)
+		}
+
+		s.Response.Append(item)
+		return nil
+	}
+}
+
+func RemoveReadingListItem(idPath string, rootPath string) HandlerFunc[ReadingListItem] {
+	return func(s *Session[ReadingListItem]) error {
+		list, err := loadOwnedList(&s.RequestContext, idPath)
+		if err != nil {
+			return err
+		}
+
+		root, err := bson.ObjectIDFromHex(s.Request.PathValue(rootPath))
+		if err != nil {
+			return NewStatusString("invalid root from path", http.StatusBadRequest)
+		}
+
+		now := time.Now()
+		item := ReadingListItem{ListId: list.ID, Root: root, Removed: true, TtlStart: &now}
+		if err := km.Record(s.Request.Context(), &item); err != nil {
+			return fmt.Errorf("ReadingListItem record error: %v", err)
+		}
+		return nil
+	}
+}
+
+// PullReadingLists returns the lists of the user.
+func PullReadingLists() HandlerFunc[ReadingList] {
+	return func(s *Session[ReadingList]) error {
+		if s.Response == nil {
+			return fmt.Errorf("ReadingList Session missing Response structure")
+		}
+
+		session, err := s.RequireUser()
+		if err != nil {
+			return err
+		}
+
+		lists, err := km.Detect[ReadingList](
+			km.Fld("OwnerId").Eq(session.UserId),
+			km.Fld("deleted").Ne(true),
+		).Sort(km.Fld("UpdatedTime").Desc()).Limit(MAX_LISTS_PER_USER).PullAll(s.Request.Context())
+		if err != nil {
+			return fmt.Errorf("ReadingList request error: %v", err)
+		}
+
+		for _, list := range lists {
+			s.Response.Append(list)
+		}
+		return nil
+	}
+}
+
+// LatestPagesByRoots returns the latest version of each root: the newest
+// version is picked per root by latestVersions and then pulled by ID, so roots
+// with many versions do not crowd out the others.
+func LatestPagesByRoots(ctx context.Context, roots []bson.ObjectID) (map[bson.ObjectID]Page, error) {
+	retval := make(map[bson.ObjectID]Page, len(roots))
+	if len(roots) == 0 {
+		return retval, nil
+	}
+
+	versions, err := latestVersions(ctx, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(versions) == 0 {
+		return retval, nil
+	}
+
+	ids := make([]bson.ObjectID, 0, len(versions))
+	for _, version := range versions {
+		ids = append(ids, version.Latest)
+	}
+
+	pages, err := km.Detect[Page](
+		km.Fld("ID").In(ids),
+	).Limit(int64(len(ids))).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, page := range pages {
+		retval[page.Root] = page
+	}
+	return retval, nil
+}
+
+// PullReadingList returns the list of the idPath path value as a
+// ListTopicResponse holding the latest version of each root in list order.
+// Private lists are only visible to their owner.
+func PullReadingList(idPath string) HandlerFunc[ReadingList] {
+	return func(s *Session[ReadingList]) error {
+		id, err := bson.ObjectIDFromHex(s.Request.PathValue(idPath))
+		if err != nil {
+			return NewStatusString("invalid id from path", http.StatusBadRequest)
+		}
+
+		ctx := s.Request.Context()
+		list, err := loadReadingList(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		var userId bson.ObjectID
+		if session, err := s.VerifySession(); err == nil && session != nil {
+			userId = session.UserId
+		}
+
+		if !list.VisibleTo(userId) {
+			return NewStatusString("list not found", http.StatusNotFound)
+		}
+
+		if s.Response == nil {
+			s.Response = NewListTopicResponse(list.Name)
+		}
+		s.Response.SetTargetID(list.ID)
+
+		items, err := loadListItems(ctx, list.ID)
+		if err != nil {
+			return err
+		}
+
+		roots := make([]bson.ObjectID, 0, len(items))
+		for _, item := range items {
+			roots = append(roots, item.Root)
+		}
+
+		pages, err := LatestPagesByRoots(ctx, roots)
+		if err != nil {
+			return fmt.Errorf("ReadingList pages request error: %v", err)
+		}
+
+		for _, root := range roots {
+			page, ok := pages[root]
+			if !ok {
+				continue
+			}
+			if err := page.Sanitize(s.RequestContext); err != nil {
+				return err
+			}
+			s.Response.Append(page)
+		}
+		return nil
+	}
+}
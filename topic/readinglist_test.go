@@ -0,0 +1,52 @@
+package topic
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestPlaceListItem(t *testing.T) {
+	a, b, c := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	items := []ReadingListItem{
+		{Root: a, Position: 1024},
+		{Root: b, Position: 2048},
+	}
+
+	position, renumbered, err := placeItem(items, c, ListPlaceLast)
+	if err != nil || position != 3072 || renumbered != nil {
+		t.Errorf("expected append after last item, got %v %v %v", position, renumbered, err)
+	}
+
+	position, _, _ = placeItem(items, c, ListPlaceFirst)
+	if position != 0 {
+		t.Errorf("expected placement before first item, got %v", position)
+	}
+
+	position, _, _ = placeItem(items, c, a.Hex())
+	if position != 1536 {
+		t.Errorf("expected midpoint between a and b, got %v", position)
+	}
+
+	// moving an item ignores its current position
+	position, _, _ = placeItem(items, b, ListPlaceFirst)
+	if position != 0 {
+		t.Errorf("expected moved item to ignore its own position, got %v", position)
+	}
+
+	if _, _, err := placeItem(items, c, c.Hex()); err == nil {
+		t.Errorf("expected placement after a missing root to fail")
+	}
+
+	crowded := []ReadingListItem{
+		{Root: a, Position: 1},
+		{Root: b, Position: 1 + MIN_LIST_POSITION_GAP/2},
+	}
+	position, renumbered, err = placeItem(crowded, c, a.Hex())
+	if err != nil || len(renumbered) != 2 {
+		t.Fatalf("expected renumbering, got %v %v", renumbered, err)
+	}
+	if renumbered[0].Position != LIST_POSITION_STEP || renumbered[1].Position != 2*LIST_POSITION_STEP || position != 1.5*LIST_POSITION_STEP {
+		t.Errorf("unexpected renumbered positions %v and %v", renumbered, position)
+	}
+}
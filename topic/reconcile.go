@@ -0,0 +1,299 @@
+package topic
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+// PageStat is maintained incrementally by the Decohere hooks of Page and
+// Comment and by moderation. Reconciliation recomputes it from the source
+// collections to catch drift from failed or duplicated side effects. The
+// comment count covers the comments visible to everyone: pending, hidden,
+// removed and expiring comments are not counted.
+
+type StatDiscrepancy struct {
+	Field  string `json:"field"`
+	Stored any    `json:"stored"`
+	Actual any    `json:"actual"`
+}
+
+type ReconcileReport struct {
+	Root          bson.ObjectID     `json:"root"`
+	Missing       bool              `json:"missing,omitempty"`
+	Discrepancies []StatDiscrepancy `json:"discrepancies,omitempty"`
+	Repaired      bool              `json:"repaired,omitempty"`
+}
+
+func (r ReconcileReport) HasDrift() bool {
+	return r.Missing || len(r.Discrepancies) > 0
+}
+
+type rootCount struct {
+	Root  bson.ObjectID `bson:"root"`
+	Count int           `bson:"count"`
+}
+
+func (r rootCount) GetID() bson.ObjectID {
+	return bson.NilObjectID
+}
+
+func (r rootCount) HasID() bool {
+	return false
+}
+
+func (r rootCount) LastObserved() time.Time {
+	return time.Time{}
+}
+
+type authorRow struct {
+	Author string `bson:"author"`
+}
+
+func (r authorRow) GetID() bson.ObjectID {
+	return bson.NilObjectID
+}
+
+func (r authorRow) HasID() bool {
+	return false
+}
+
+func (r authorRow) LastObserved() time.Time {
+	return time.Time{}
+}
+
+// ComputePageStat derives the PageStat of root from its comments and versions.
+func ComputePageStat(ctx context.Context, root bson.ObjectID) (*PageStat, error) {
+	counts, err := km.ProjectInto[rootCount](
+		km.Fld("root").With().GroupKey("root"),
+		km.Fld("count").With().One(),
+	).From(
+		km.Detect[sitepages.Comment](
+			km.Fld("Root").ID().Eq(root),
+			visibleComments(),
+			km.Fld("ttl_start").Exists(false),
+		).GroupBy(
+			"root",
+		).Accumulate(
+			km.Fld("count").With().Sum(1),
+		),
+	).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	authors, err := km.ProjectInto[authorRow](
+		km.Fld("author").With().GroupKey("author"),
+	).From(
+		km.Detect[sitepages.Page](
+			km.Fld("Root").ID().Eq(root),
+		).GroupBy("author"),
+	).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	top, err := TopPageByRoot(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := pageStatFromRows(root, counts, authors, top)
+	return &stat, nil
+}
+
+// pageStatFromRows builds the PageStat of root from its visible comment
+// counts, the authors of its versions and its top version. Only page authors
+// are authors of a root; commenters are not.
+func pageStatFromRows(root bson.ObjectID, counts []rootCount, authors []authorRow, top *PageRank) PageStat {
+	stat := PageStat{}
+	stat.ID = root
+	for _, count := range counts {
+		stat.CommentCount += count.Count
+	}
+	for _, author := range authors {
+		if author.Author != "" {
+			stat.Authors = appendUnique(stat.Authors, author.Author)
+		}
+	}
+	slices.Sort(stat.Authors)
+	if top != nil {
+		stat.Title = top.Title
+	}
+	return stat
+}
+
+func comparePageStat(stored PageStat, actual PageStat) []StatDiscrepancy {
+	var discrepancies []StatDiscrepancy
+	if stored.CommentCount != actual.CommentCount {
+		discrepancies = append(discrepancies, StatDiscrepancy{Field: "comment_count", Stored: stored.CommentCount, Actual: actual.CommentCount})
+	}
+
+	storedAuthors := slices.Clone(stored.Authors)
+	slices.Sort(storedAuthors)
+	storedAuthors = slices.Compact(storedAuthors)
+	if !slices.Equal(storedAuthors, actual.Authors) {
+		discrepancies = append(discrepancies, StatDiscrepancy{Field: "authors", Stored: stored.Authors, Actual: actual.Authors})
+	}
+
+	if stored.Title != actual.Title {
+		discrepancies = append(discrepancies, StatDiscrepancy{Field: "title", Stored: stored.Title, Actual: actual.Title})
+	}
+	return discrepancies
+}
+
+// ReconcilePageStat compares the stored PageStat of root with its recomputed
+// value and, when repair is set, overwrites the drifted counters.
+func ReconcilePageStat(ctx context.Context, root bson.ObjectID, repair bool) (*ReconcileReport, error) {
+	actual, err := ComputePageStat(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+
+	stored, err := km.Detect[PageStat](km.Fld("ID").Eq(root)).Limit(1).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := ReconcileReport{Root: root}
+	if len(stored) == 0 {
+		report.Missing = true
+	} else {
+		report.Discrepancies = comparePageStat(stored[0], *actual)
+		// views are not derivable from source collections: keep them
+		actual.ViewCount = stored[0].ViewCount
+	}
+
+	if repair && report.HasDrift() {
+		if err := km.Record(ctx, actual); err != nil {
+			return &report, fmt.Errorf("ReconcilePageStat: failed to repair %s: %v", root.Hex(), err)
+		}
+		report.Repaired = true
+	}
+	return &report, nil
+}
+
+// ReconcileRootStat reports to moderators the drift of the PageStat of the
+// root of the idPath path value. The "repair" query parameter set to true
+// also overwrites the drifted counters.
+func ReconcileRootStat(idPath string) HandlerFunc[PageStat] {
+	return func(s *Session[PageStat]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Reconcile Session missing Response structure")
+		}
+
+		if _, err := s.RequireModerator(); err != nil {
+			return err
+		}
+
+		root, err := bson.ObjectIDFromHex(s.Request.PathValue(idPath))
+		if err != nil {
+			return NewStatusString("invalid id from path", http.StatusBadRequest)
+		}
+
+		report, err := ReconcilePageStat(s.Request.Context(), root, s.URLQuery().Get("repair") == "true")
+		if err != nil {
+			return fmt.Errorf("Reconcile request error: %v", err)
+		}
+
+		s.Response.SetTargetID(root)
+		s.Response.Append(*report)
+		return nil
+	}
+}
+
+type rootRow struct {
+	Root bson.ObjectID `bson:"root"`
+}
+
+func (r rootRow) GetID() bson.ObjectID {
+	return bson.NilObjectID
+}
+
+func (r rootRow) HasID() bool {
+	return false
+}
+
+func (r rootRow) LastObserved() time.Time {
+	return time.Time{}
+}
+
+// AllPageRoots lists every root that has at least one page version.
+func AllPageRoots(ctx context.Context) ([]bson.ObjectID, error) {
+	rows, err := km.ProjectInto[rootRow](
+		km.Fld("root").With().GroupKey("root"),
+	).From(
+		km.All[sitepages.Page]().GroupBy("root"),
+	).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	roots := make([]bson.ObjectID, 0, len(rows))
+	for _, row := range rows {
+		if !row.Root.IsZero() {
+			roots = append(roots, row.Root)
+		}
+	}
+	return roots, nil
+}
+
+// ReconcileAllPageStats reconciles every root and hands each drifted report
+// to report. A failing root is logged and does not stop the run; the number
+// of drifted roots is returned.
+func ReconcileAllPageStats(ctx context.Context, repair bool, report func(ReconcileReport)) (int, error) {
+	roots, err := AllPageRoots(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	drifted := 0
+	for _, root := range roots {
+		if err := ctx.Err(); err != nil {
+			return drifted, err
+		}
+
+		result, err := ReconcilePageStat(ctx, root, repair)
+		if err != nil {
+			log.Printf("Reconcile PageStat %s failed: %v", root.Hex(), err)
+			continue
+		}
+
+		if result.HasDrift() {
+			drifted++
+			if report != nil {
+				report(*result)
+			}
+		}
+	}
+	return drifted, nil
+}
+
+// RunPageStatReconciliation reconciles all roots every interval until ctx is done.
+func RunPageStatReconciliation(ctx context.Context, interval time.Duration, repair bool) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			drifted, err := ReconcileAllPageStats(ctx, repair, func(r ReconcileReport) {
+				log.Printf("PageStat drift on %s: missing=%v %v repaired=%v", r.Root.Hex(), r.Missing, r.Discrepancies, r.Repaired)
+			})
+			if err != nil {
+				log.Printf("PageStat reconciliation failed: %v", err)
+				continue
+			}
+			log.Printf("PageStat reconciliation found %d drifted roots", drifted)
+		}
+	}
+}
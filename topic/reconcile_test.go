@@ -0,0 +1,28 @@
+package topic
+
+import (
+	"testing"
+)
+
+func TestComparePageStat(t *testing.T) {
+	actual := PageStat{Title: "Top", CommentCount: 4, Authors: []string{"ada", "bob"}}
+
+	stored := PageStat{Title: "Top", CommentCount: 4, Authors: []string{"bob", "ada", "bob"}}
+	if discrepancies := comparePageStat(stored, actual); len(discrepancies) != 0 {
+		t.Errorf("expected author order and duplicates to be ignored, got %v", discrepancies)
+	}
+
+	stored = PageStat{CommentCount: 6, Authors: []string{"ada"}}
+	discrepancies := comparePageStat(stored, actual)
+	if len(discrepancies) != 3 {
+		t.Fatalf("expected 3 discrepancies, got %v", discrepancies)
+	}
+
+	fields := []string{discrepancies[0].Field, discrepancies[1].Field, discrepancies[2].Field}
+	if fields[0] != "comment_count" || fields[1] != "authors" || fields[2] != "title" {
+		t.Errorf("unexpected discrepancy fields %v", fields)
+	}
+	if discrepancies[0].Stored != 6 || discrepancies[0].Actual != 4 {
+		t.Errorf("unexpected comment count discrepancy %+v", discrepancies[0])
+	}
+}
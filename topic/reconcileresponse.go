@@ -0,0 +1,27 @@
+package topic
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type ReconcileResponse struct {
+	EntangledResponse
+	ReconcileData []ReconcileReport `xml:"-" json:"ReconcileData,omitempty" bson:"-" `
+}
+
+func NewReconcileResponse() Response {
+	return &ReconcileResponse{}
+}
+
+func (rr *ReconcileResponse) Append(data any) bson.ObjectID {
+	switch response := data.(type) {
+	case ReconcileReport:
+		rr.ReconcileData = append(rr.ReconcileData, response)
+		return response.Root
+	case *ReconcileReport:
+		rr.ReconcileData = append(rr.ReconcileData, *response)
+		return response.Root
+	}
+
+	return rr.EntangledResponse.Append(data)
+}
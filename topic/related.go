@@ -0,0 +1,376 @@
+package topic
+
+import (
+	"cmp"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"slices"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_RELATED_CANDIDATES int64 = 500
+var MAX_RELATED_BOOKMARKERS int64 = 200
+var MAX_RELATED_BOOKMARKS int64 = 2000
+
+const (
+	RelatedTag        = "tag"
+	RelatedCategory   = "category"
+	RelatedLinksTo    = "links_to"
+	RelatedLinkedFrom = "linked_from"
+	RelatedCoBookmark = "cobookmark"
+)
+
+type RelatedWeights struct {
+	Tag        float64 `json:"tag"`
+	Category   float64 `json:"category"`
+	Synapse    float64 `json:"synapse"`
+	CoBookmark float64 `json:"cobookmark"`
+}
+
+var DefaultRelatedWeights = RelatedWeights{Tag: 1.0, Category: 0.5, Synapse: 3.0, CoBookmark: 1.0}
+
+type RelatedReason struct {
+	Kind  string  `xml:"kind" json:"Kind"`
+	Value string  `xml:"value,omitempty" json:"Value,omitempty"`
+	Count int     `xml:"count,omitempty" json:"Count,omitempty"`
+	Score float64 `xml:"score" json:"Score"`
+}
+
+type Related struct {
+	XMLName xml.Name        `xml:"related" json:"-" bson:"-"`
+	Root    bson.ObjectID   `xml:"root" json:"root"`
+	Title   string          `xml:"title,omitempty" json:"title,omitempty"`
+	Score   float64         `xml:"score" json:"Score"`
+	Reasons []RelatedReason `xml:"reason" json:"Reasons"`
+}
+
+// RelatedCorpus is everything ScoreRelated needs about a root and its
+// neighbourhood. Pages may hold several versions per root; the latest one
+// (see isNewerPage) stands for its root. Bookmarks point at page versions.
+type RelatedCorpus struct {
+	Pages     []sitepages.Page
+	Bookmarks []LinkDescription
+}
+
+// ScoreRelated ranks the roots of the corpus by how related they are to root,
+// best first, with the reasons that make up each score.
+func ScoreRelated(root bson.ObjectID, corpus RelatedCorpus, weights RelatedWeights) []Related {
+	rootOf := make(map[bson.ObjectID]bson.ObjectID, len(corpus.Pages))
+	latest := make(map[bson.ObjectID]sitepages.Page)
+	for _, page := range corpus.Pages {
+		rootOf[page.ID] = page.Root
+		current, ok := latest[page.Root]
+		if !ok || isNewerPage(page, current) {
+			latest[page.Root] = page
+		}
+	}
+
+	target, ok := latest[root]
+	if !ok {
+		return nil
+	}
+
+	// a synapse may point at a page version or directly at a root
+	synapseRoot := func(id bson.ObjectID) bson.ObjectID {
+		if r, ok := rootOf[id]; ok {
+			return r
+		}
+		return id
+	}
+
+	byRoot := make(map[bson.ObjectID]*Related)
+	addReason := func(other bson.ObjectID, reason RelatedReason) {
+		if other.IsZero() || other == root || reason.Score == 0 {
+			return
+		}
+		related, ok := byRoot[other]
+		if !ok {
+			related = &Related{Root: other}
+			byRoot[other] = related
+		}
+		related.Score += reason.Score
+		related.Reasons = append(related.Reasons, reason)
+	}
+
+	for other, page := range latest {
+		if other == root {
+			continue
+		}
+
+		for _, tag := range page.Infos.Tags {
+			if slices.Contains(target.Infos.Tags, tag) {
+				addReason(other, RelatedReason{Kind: RelatedTag, Value: tag, Score: weights.Tag})
+			}
+		}
+
+		if target.Infos.Category != "" && page.Infos.Category == target.Infos.Category {
+			addReason(other, RelatedReason{Kind: RelatedCategory, Value: page.Infos.Category, Score: weights.Category})
+		}
+
+		for _, synapse := range page.Synapses {
+			if synapseRoot(synapse.ToPageId) == root {
+				addReason(other, RelatedReason{Kind: RelatedLinkedFrom, Score: weights.Synapse})
+				break
+			}
+		}
+	}
+
+	linked := make(map[bson.ObjectID]bool)
+	for _, synapse := range target.Synapses {
+		other := synapseRoot(synapse.ToPageId)
+		if _, ok := latest[other]; ok && !linked[other] {
+			linked[other] = true
+			addReason(other, RelatedReason{Kind: RelatedLinksTo, Score: weights.Synapse})
+		}
+	}
+
+	bookmarkers := make(map[bson.ObjectID]bool)
+	for _, bookmark := range corpus.Bookmarks {
+		if rootOf[bookmark.ObjectId] == root {
+			bookmarkers[bookmark.SubjectId] = true
+		}
+	}
+
+	type userRoot struct {
+		user bson.ObjectID
+		root bson.ObjectID
+	}
+	seen := make(map[userRoot]bool)
+	coBookmarks := make(map[bson.ObjectID]int)
+	for _, bookmark := range corpus.Bookmarks {
+		other, ok := rootOf[bookmark.ObjectId]
+		if !ok || other == root || !bookmarkers[bookmark.SubjectId] {
+			continue
+		}
+		key := userRoot{bookmark.SubjectId, other}
+		if !seen[key] {
+			seen[key] = true
+			coBookmarks[other]++
+		}
+	}
+	for other, count := range coBookmarks {
+		addReason(other, RelatedReason{Kind: RelatedCoBookmark, Count: count, Score: weights.CoBookmark * float64(count)})
+	}
+
+	retval := make([]Related, 0, len(byRoot))
+	for _, related := range byRoot {
+		retval = append(retval, *related)
+	}
+
+	slices.SortFunc(retval, func(a, b Related) int {
+		if c := cmp.Compare(b.Score, a.Score); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Root.Hex(), b.Root.Hex())
+	})
+	return retval
+}
+
+// LoadRelatedCorpus gathers the versions of root, the pages sharing its tags
+// or category, the pages linked to or from it, and the bookmarks of the users
+// who bookmarked it. Matches may be older versions, so the latest version of
+// every matched root is loaded as well.
+func LoadRelatedCorpus(ctx context.Context, root bson.ObjectID) (*RelatedCorpus, error) {
+	targets, err := km.Detect[sitepages.Page](
+		km.Fld("Root").ID().Eq(root),
+	).Sort(newestFirst()...).Limit(int64(MAX_VERSIONS_PER_ROOT)).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	corpus := &RelatedCorpus{Pages: targets}
+	if len(targets) == 0 {
+		return corpus, nil
+	}
+
+	target := targets[0]
+	targetIDs := []bson.ObjectID{root}
+	for _, page := range targets {
+		targetIDs = append(targetIDs, page.ID)
+	}
+
+	alternatives := []expression.QueryFieldPredicate{
+		km.Fld("synapses.to_page_id").In(targetIDs),
+	}
+	if len(target.Infos.Tags) > 0 {
+		alternatives = append(alternatives, km.Fld("infos.tags").In(target.Infos.Tags))
+	}
+	if target.Infos.Category != "" {
+		alternatives = append(alternatives, km.Fld("infos.category").Eq(target.Infos.Category))
+	}
+
+	var linkedIDs []bson.ObjectID
+	for _, synapse := range target.Synapses {
+		if !synapse.ToPageId.IsZero() {
+			linkedIDs = append(linkedIDs, synapse.ToPageId)
+		}
+	}
+	if len(linkedIDs) > 0 {
+		alternatives = append(alternatives,
+			km.Fld("ID").In(linkedIDs),
+			km.Fld("Root").ID().In(linkedIDs),
+		)
+	}
+
+	candidates, err := km.Detect[sitepages.Page](
+		km.Or(alternatives...),
+	).Sort(newestFirst()...).Limit(MAX_RELATED_CANDIDATES).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+	corpus.Pages = append(corpus.Pages, candidates...)
+
+	bookmarks, err := km.Detect[UserToPageLink](
+		km.Fld("objid").In(targetIDs),
+		km.Fld("relation").Eq(RelationBookmarked),
+		km.Fld("state").Eq(RelationActive),
+	).Limit(MAX_RELATED_BOOKMARKERS).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(bookmarks) == 0 {
+		return corpus, addLatestVersions(ctx, corpus, root)
+	}
+
+	var users []bson.ObjectID
+	for _, bookmark := range bookmarks {
+		if !slices.Contains(users, bookmark.SubjectId) {
+			users = append(users, bookmark.SubjectId)
+		}
+	}
+
+	coBookmarks, err := km.Detect[UserToPageLink](
+		km.Fld("subjid").In(users),
+		km.Fld("relation").Eq(RelationBookmarked),
+		km.Fld("state").Eq(RelationActive),
+	).Limit(MAX_RELATED_BOOKMARKS).PullAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[bson.ObjectID]bool, len(corpus.Pages))
+	for _, page := range corpus.Pages {
+		known[page.ID] = true
+	}
+
+	var unknown []bson.ObjectID
+	for _, bookmark := range coBookmarks {
+		corpus.Bookmarks = append(corpus.Bookmarks, bookmark.LinkDescription)
+		if !known[bookmark.ObjectId] {
+			known[bookmark.ObjectId] = true
+			unknown = append(unknown, bookmark.ObjectId)
+		}
+	}
+
+	if len(unknown) > 0 {
+		pages, err := km.Detect[sitepages.Page](
+			km.Fld("ID").In(unknown),
+		).Limit(int64(len(unknown))).PullAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		corpus.Pages = append(corpus.Pages, pages...)
+	}
+	return corpus, addLatestVersions(ctx, corpus, root)
+}
+
+// addLatestVersions adds to the corpus the latest version of every root it
+// holds but root, reduced to the fields ScoreRelated reads.
+func addLatestVersions(ctx context.Context, corpus *RelatedCorpus, root bson.ObjectID) error {
+	var roots []bson.ObjectID
+	for _, page := range corpus.Pages {
+		if page.Root != root && !slices.Contains(roots, page.Root) {
+			roots = append(roots, page.Root)
+		}
+	}
+
+	if len(roots) == 0 {
+		return nil
+	}
+
+	latest, err := km.ProjectInto[sitepages.Page](
+		km.Fld("root").With().GroupKey("root"),
+		km.Fld("_id").With().One(),
+		km.Fld("event_at").With().One(),
+		km.Fld("infos").With().One(),
+		km.Fld("synapses").With().One(),
+	).From(
+		km.Detect[sitepages.Page](
+			km.Fld("Root").ID().In(roots),
+		).Sort(
+			newestFirst()...,
+		).GroupBy(
+			"root",
+		).Accumulate(
+			km.Fld("_id").With().First("_id"),
+			km.Fld("event_at").With().First("event_at"),
+			km.Fld("infos").With().First("infos"),
+			km.Fld("synapses").With().First("synapses"),
+		),
+	).PullAll(ctx)
+	if err != nil {
+		return err
+	}
+
+	corpus.Pages = append(corpus.Pages, latest...)
+	return nil
+}
+
+// PullRelatedRoots returns the roots most related to the root of the session
+// (see SetRootIDFromPath) with the reasons of their relation, titled by their
+// top version under the scorer of the "feed" query parameter.
+func PullRelatedRoots(limit int) HandlerFunc[PageStat] {
+	return func(s *Session[PageStat]) error {
+		if s.RootId == nil {
+			return fmt.Errorf("Related Session missing RootId")
+		}
+
+		if s.Response == nil {
+			return fmt.Errorf("Related Session missing Response structure")
+		}
+
+		scorer, err := ScorerFromQuery(&s.RequestContext)
+		if err != nil {
+			return err
+		}
+
+		ctx := s.Request.Context()
+		corpus, err := LoadRelatedCorpus(ctx, *s.RootId)
+		if err != nil {
+			return fmt.Errorf("LoadRelatedCorpus request error: %v", err)
+		}
+
+		related := ScoreRelated(*s.RootId, *corpus, DefaultRelatedWeights)
+		if len(related) > limit {
+			related = related[:limit]
+		}
+
+		if len(related) == 0 {
+			return nil
+		}
+
+		roots := make([]bson.ObjectID, 0, len(related))
+		for _, r := range related {
+			roots = append(roots, r.Root)
+		}
+
+		tops, err := TopPagesByRoots(ctx, roots, scorer)
+		if err != nil {
+			return fmt.Errorf("Related TopPagesByRoots request error: %v", err)
+		}
+
+		for _, r := range related {
+			if top, ok := tops[r.Root]; ok {
+				r.Title = top.Title
+			}
+			s.Response.Append(r)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,84 @@
+package topic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestScoreRelated(t *testing.T) {
+	now := time.Now()
+	root := bson.NewObjectID()
+	tagged := bson.NewObjectID()
+	linked := bson.NewObjectID()
+	linking := bson.NewObjectID()
+	bookmarked := bson.NewObjectID()
+	unrelated := bson.NewObjectID()
+
+	page := func(r bson.ObjectID, at time.Time, infos sitepages.MetaInfo, synapses ...sitepages.Synapse) sitepages.Page {
+		p := sitepages.Page{Root: r, Infos: infos, Synapses: synapses, EventAt: at}
+		p.ID = bson.NewObjectID()
+		return p
+	}
+
+	linkedPage := page(linked, now, sitepages.MetaInfo{})
+	target := page(root, now, sitepages.MetaInfo{Category: "news", Tags: []string{"go", "db"}},
+		sitepages.Synapse{ToPageId: linkedPage.ID})
+	bookmarkedPage := page(bookmarked, now, sitepages.MetaInfo{})
+
+	corpus := RelatedCorpus{
+		Pages: []sitepages.Page{
+			target,
+			page(root, now.Add(-time.Hour), sitepages.MetaInfo{Tags: []string{"old"}}), // older version is ignored
+			page(tagged, now, sitepages.MetaInfo{Category: "news", Tags: []string{"go", "db", "web"}}),
+			linkedPage,
+			page(linking, now, sitepages.MetaInfo{}, sitepages.Synapse{ToPageId: root}),
+			bookmarkedPage,
+			page(unrelated, now, sitepages.MetaInfo{Category: "sports", Tags: []string{"old"}}),
+		},
+	}
+
+	ada, bob, eve := bson.NewObjectID(), bson.NewObjectID(), bson.NewObjectID()
+	corpus.Bookmarks = []LinkDescription{
+		{SubjectId: ada, ObjectId: target.ID},
+		{SubjectId: bob, ObjectId: target.ID},
+		{SubjectId: ada, ObjectId: bookmarkedPage.ID},
+		{SubjectId: ada, ObjectId: bookmarkedPage.ID}, // duplicate bookmark counts once
+		{SubjectId: bob, ObjectId: bookmarkedPage.ID},
+		{SubjectId: eve, ObjectId: linkedPage.ID}, // eve never bookmarked the root
+	}
+
+	related := ScoreRelated(root, corpus, DefaultRelatedWeights)
+	if len(related) != 4 {
+		t.Fatalf("expected 4 related roots, got %+v", related)
+	}
+
+	scores := make(map[bson.ObjectID]Related)
+	for _, r := range related {
+		scores[r.Root] = r
+	}
+
+	if _, ok := scores[unrelated]; ok {
+		t.Errorf("unrelated root should not be related")
+	}
+	if r := scores[tagged]; r.Score != 2.5 || len(r.Reasons) != 3 {
+		t.Errorf("expected 2 shared tags and category for tagged root, got %+v", r)
+	}
+	if r := scores[linked]; r.Score != 3.0 || r.Reasons[0].Kind != RelatedLinksTo {
+		t.Errorf("expected outgoing synapse reason, got %+v", r)
+	}
+	if r := scores[linking]; r.Score != 3.0 || r.Reasons[0].Kind != RelatedLinkedFrom {
+		t.Errorf("expected incoming synapse reason, got %+v", r)
+	}
+	if r := scores[bookmarked]; r.Score != 2.0 || r.Reasons[0].Kind != RelatedCoBookmark || r.Reasons[0].Count != 2 {
+		t.Errorf("expected 2 co-bookmarks, got %+v", r)
+	}
+
+	for i := 1; i < len(related); i++ {
+		if related[i-1].Score < related[i].Score {
+			t.Errorf("related roots not sorted by score: %+v", related)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package topic
+
+import (
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type RelatedTopicResponse struct {
+	EntangledResponse
+	RelatedData []Related `xml:"-" json:"RelatedData,omitempty" bson:"-" `
+}
+
+func NewRelatedTopicResponse() Response {
+	return &RelatedTopicResponse{}
+}
+
+func (rr *RelatedTopicResponse) Append(data any) bson.ObjectID {
+	switch response := data.(type) {
+	case Related:
+		rr.RelatedData = append(rr.RelatedData, response)
+		return response.Root
+	case *Related:
+		rr.RelatedData = append(rr.RelatedData, *response)
+		return response.Root
+	}
+
+	return rr.EntangledResponse.Append(data)
+}
@@ -1,13 +1,21 @@
 package topic
 
 import (
+	"context"
 	"fmt"
 
 	"git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
 	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"github.com/borghives/sitepages"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
+const (
+	RelationActive   = "active"
+	RelationInactive = "inactive"
+)
+
 type LinkDescription struct {
 	SubjectId bson.ObjectID `json:"-" bson:"subjid"`
 	ObjectId  bson.ObjectID `json:"ObjId" bson:"objid"`
@@ -55,15 +63,111 @@ func (l UserToPageLink) SelfScope() expression.Scope {
 	)
 }
 
+func (l *UserToPageLink) Link() *LinkDescription {
+	return &l.LinkDescription
+}
+
+func (l UserToPageLink) ObjectKind() string {
+	return KindPage
+}
+
+// Decohere drops the cached ranking of the root of the linked page.
+func (l *UserToPageLink) Decohere(ripple matter.Ripple) error {
+	err := l.BaseModel.Decohere(ripple)
+	if err != nil {
+		return err
+	}
+
+	if FeedCache == nil {
+		return nil
+	}
+
+	pages, err := kosmos.Detect[sitepages.Page](
+		kosmos.Fld("ID").Eq(l.ObjectId),
+	).Limit(1).PullAll(context.Background())
+	if err != nil || len(pages) == 0 {
+		return nil
+	}
+
+	invalidateFeed(context.Background(), cacheRootTag(pages[0].Root))
+	return nil
+}
+
 type UserToCommentLink struct {
 	kosmos.BaseModel `bson:",inline" kosmos:"rel_commentrelation"`
 	LinkDescription  `bson:",inline"`
 }
 
+// SelfScope keeps a single vote per user on a comment, and a report beside it.
 func (l UserToCommentLink) SelfScope() expression.Scope {
+	if l.Relation == RelationReported {
+		return expression.CreateScope(
+			kosmos.Fld("SubjectId").Eq(l.SubjectId),
+			kosmos.Fld("ObjectId").Eq(l.ObjectId),
+			kosmos.Fld("Name").Eq(l.Name),
+			kosmos.Fld("Relation").Eq(l.Relation),
+		)
+	}
+
 	return expression.CreateScope(
 		kosmos.Fld("SubjectId").Eq(l.SubjectId),
 		kosmos.Fld("ObjectId").Eq(l.ObjectId),
 		kosmos.Fld("Name").Eq(l.Name),
+		kosmos.Fld("Relation").Ne(RelationReported),
 	)
 }
+
+func (l *UserToCommentLink) Link() *LinkDescription {
+	return &l.LinkDescription
+}
+
+func (l UserToCommentLink) ObjectKind() string {
+	return KindComment
+}
+
+// UserToRootLink relates a user to a page root rather than to one version.
+type UserToRootLink struct {
+	kosmos.BaseModel `bson:",inline" kosmos:"user_root"`
+	LinkDescription  `bson:",inline"`
+}
+
+func (l UserToRootLink) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		kosmos.Fld("SubjectId").Eq(l.SubjectId),
+		kosmos.Fld("ObjectId").Eq(l.ObjectId),
+		kosmos.Fld("Name").Eq(l.Name),
+		kosmos.Fld("Relation").Eq(l.Relation),
+	)
+}
+
+func (l *UserToRootLink) Link() *LinkDescription {
+	return &l.LinkDescription
+}
+
+func (l UserToRootLink) ObjectKind() string {
+	return KindRoot
+}
+
+// UserToAuthorLink relates a user to an author; the object is the ID of the
+// author's AuthorStat.
+type UserToAuthorLink struct {
+	kosmos.BaseModel `bson:",inline" kosmos:"user_author"`
+	LinkDescription  `bson:",inline"`
+}
+
+func (l UserToAuthorLink) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		kosmos.Fld("SubjectId").Eq(l.SubjectId),
+		kosmos.Fld("ObjectId").Eq(l.ObjectId),
+		kosmos.Fld("Name").Eq(l.Name),
+		kosmos.Fld("Relation").Eq(l.Relation),
+	)
+}
+
+func (l *UserToAuthorLink) Link() *LinkDescription {
+	return &l.LinkDescription
+}
+
+func (l UserToAuthorLink) ObjectKind() string {
+	return KindAuthor
+}
@@ -0,0 +1,338 @@
+package topic
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"github.com/borghives/sitepages"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_LINKS_PER_OBJECT int64 = 50
+
+// relationLink is implemented by the pointer of the user relation models.
+type relationLink[T any] interface {
+	*T
+	matter.Detectable
+	Link() *LinkDescription
+	ObjectKind() string
+}
+
+// PutRelation activates the relation named by the relationPath path value of
+// the verified user on the object of the idPath path value.
+func PutRelation[T matter.Detectable, PT relationLink[T]](idPath string, relationPath string) HandlerFunc[T] {
+	return setRelationState[T, PT](idPath, relationPath, RelationActive)
+}
+
+// DeleteRelation deactivates the relation; the link is kept so that it can be
+// restored and its history counted.
+func DeleteRelation[T matter.Detectable, PT relationLink[T]](idPath string, relationPath string) HandlerFunc[T] {
+	return setRelationState[T, PT](idPath, relationPath, RelationInactive)
+}
+
+// setRelationState is idempotent: a link already in the requested state is
+// returned as is without a write.
+func setRelationState[T matter.Detectable, PT relationLink[T]](idPath string, relationPath string, state string) HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Relation Session missing Response structure")
+		}
+
+		session, err := s.RequireUser()
+		if err != nil {
+			return err
+		}
+
+		objectId, err := bson.ObjectIDFromHex(s.Request.PathValue(idPath))
+		if err != nil {
+			return NewStatusString("invalid id from path", http.StatusBadRequest)
+		}
+
+		relation := s.Request.PathValue(relationPath)
+		if relation == "" {
+			return NewStatusString("missing relation", http.StatusBadRequest)
+		}
+
+		ctx := s.Request.Context()
+		var link T
+		PT(&link).Link().Relation = relation
+		objectKind := PT(&link).ObjectKind()
+
+		existing, err := km.Detect[T](
+			km.Fld("subjid").Eq(session.UserId),
+			km.Fld("objid").Eq(objectId),
+		).Limit(MAX_LINKS_PER_OBJECT).PullAll(ctx)
+		if err != nil {
+			return fmt.Errorf("Relation request error: %v", err)
+		}
+
+		// comment links hold a single vote per user, so a new vote replaces
+		// the previous one instead of adding to it
+		var current PT
+		for i := range existing {
+			candidate := PT(&existing[i])
+			if candidate.Link().Name != "" {
+				continue // list membership, not a relation
+			}
+			if candidate.Link().Relation == relation || (objectKind == KindComment && isCommentVote(relation) && isCommentVote(candidate.Link().Relation)) {
+				current = candidate
+				break
+			}
+		}
+
+		previousState := ""
+		if current != nil && current.Link().Relation == relation {
+			previousState = current.Link().State
+		}
+
+		if previousState == state || (previousState == "" && state == RelationInactive) {
+			if current != nil && current.Link().Relation == relation {
+				s.Response.Append(*current)
+			}
+			return appendRelationCounts[T](ctx, s.Response, objectKind, objectId)
+		}
+
+		if previousState == "" {
+			if err := checkRelationObject(ctx, objectKind, objectId); err != nil {
+				return err
+			}
+		}
+
+		next := PT(&link).Link()
+		next.SubjectId = session.UserId
+		next.ObjectId = objectId
+		next.CreatorId = session.UserId
+		next.State = state
+		if err := ValidateLink(KindUser, objectKind, *next, previousState); err != nil {
+			return err
+		}
+
+		// upserted by SelfScope: this updates the current link if any
+		if err := km.Record(ctx, PT(&link)); err != nil {
+			return fmt.Errorf("Relation record error: %v", err)
+		}
+
+		if current == nil && state == RelationActive {
+			creditRelation(ctx, session.UserId, objectKind, objectId, relation)
+		}
+
+		if objectKind == KindComment && isCommentVote(relation) {
+			if err := RescoreComment(ctx, objectId); err != nil {
+				log.Printf("Relation: failed to rescore comment %v", err)
+			}
+		}
+
+		s.Response.Append(link)
+		return appendRelationCounts[T](ctx, s.Response, objectKind, objectId)
+	}
+}
+
+func isCommentVote(relation string) bool {
+	return relation == CommentUpvote || relation == CommentDownvote
+}
+
+func appendRelationCounts[T matter.Detectable](ctx context.Context, response Response, objectKind string, objectId bson.ObjectID) error {
+	counts, err := relationCounts[T](ctx, objectKind, []bson.ObjectID{objectId})
+	if err != nil {
+		return fmt.Errorf("Relation count request error: %v", err)
+	}
+
+	for _, count := range counts[objectId] {
+		response.Append(count)
+	}
+	return nil
+}
+
+type relationObject struct {
+	root   bson.ObjectID
+	author string
+}
+
+func lookupRelationObject(ctx context.Context, objectKind string, objectId bson.ObjectID) (*relationObject, error) {
+	switch objectKind {
+	case KindPage:
+		pages, err := km.Detect[sitepages.Page](km.Fld("ID").Eq(objectId)).Limit(1).PullAll(ctx)
+		if err != nil || len(pages) == 0 {
+			return nil, err
+		}
+		return &relationObject{root: pages[0].Root, author: pages[0].Author}, nil
+	case KindComment:
+		comments, err := km.Detect[sitepages.Comment](km.Fld("ID").Eq(objectId)).Limit(1).PullAll(ctx)
+		if err != nil || len(comments) == 0 {
+			return nil, err
+		}
+		return &relationObject{root: comments[0].Root, author: comments[0].UserName}, nil
+	case KindRoot:
+		top, err := TopPageByRoot(ctx, objectId)
+		if err != nil || top == nil {
+			return nil, err
+		}
+		return &relationObject{root: objectId, author: top.Author}, nil
+	case KindAuthor:
+		authors, err := km.Detect[AuthorStat](km.Fld("ID").Eq(objectId)).Limit(1).PullAll(ctx)
+		if err != nil || len(authors) == 0 {
+			return nil, err
+		}
+		return &relationObject{author: authors[0].Name}, nil
+	}
+	return nil, nil
+}
+
+func checkRelationObject(ctx context.Context, objectKind string, objectId bson.ObjectID) error {
+	object, err := lookupRelationObject(ctx, objectKind, objectId)
+	if err != nil {
+		return fmt.Errorf("Relation object request error: %v", err)
+	}
+
+	if object == nil {
+		return NewStatusString("relation object not found", http.StatusNotFound)
+	}
+	return nil
+}
+
+// relationCredit marks that a subject was credited for relating to an object.
+// Every crediting write increments Grants atomically and reads it back, so only
+// the write that takes it to one credits, however many race.
+type relationCredit struct {
+	km.BaseModel `bson:",inline" kosmos:"relation_credit"`
+	SubjectId    bson.ObjectID `bson:"subjid"`
+	ObjectId     bson.ObjectID `bson:"objid"`
+	Family       string        `bson:"family"`
+	Grants       int           `bson:"grants"`
+}
+
+func (c relationCredit) SelfScope() expression.Scope {
+	return expression.CreateScope(
+		km.Fld("SubjectId").Eq(c.SubjectId),
+		km.Fld("ObjectId").Eq(c.ObjectId),
+		km.Fld("Family").Eq(c.Family),
+	)
+}
+
+func (c *relationCredit) Collapse() matter.Ripple {
+	ripple := c.BaseModel.Collapse()
+	ripple.Set("subjid", c.SubjectId)
+	ripple.Set("objid", c.ObjectId)
+	ripple.Set("family", c.Family)
+	ripple.DoIncr("grants", 1)
+	return ripple
+}
+
+func (c *relationCredit) Decohere(ripple matter.Ripple) error {
+	if grants, ok := ripple.Get("grants"); ok {
+		c.Grants = grants.(int)
+	}
+	return c.BaseModel.Decohere(ripple)
+}
+
+// creditRelation credits the root activity and the author of the object the
+// first time a subject relates to it with a relation of positive weight.
+// Comment votes are one family: switching a vote is not a new relation. It
+// only logs on failure.
+func creditRelation(ctx context.Context, subjectId bson.ObjectID, objectKind string, objectId bson.ObjectID, relation string) {
+	relationType, ok := LookupRelation(KindUser, objectKind, relation)
+	if !ok || relationType.Weight <= 0 {
+		return
+	}
+
+	family := relation
+	if objectKind == KindComment && isCommentVote(relation) {
+		family = "vote"
+	}
+
+	credit := relationCredit{SubjectId: subjectId, ObjectId: objectId, Family: objectKind + ":" + family}
+	if err := km.Record(ctx, &credit); err != nil {
+		log.Printf("Relation: failed to record credit %v", err)
+		return
+	}
+
+	if credit.Grants != 1 {
+		return
+	}
+
+	object, err := lookupRelationObject(ctx, objectKind, objectId)
+	if err != nil || object == nil {
+		return
+	}
+
+	if err := RecordActivity(ctx, object.root, ActivityRelation); err != nil {
+		log.Printf("Relation: failed to record root activity %v", err)
+	}
+
+	if err := RecordAuthorContribution(ctx, object.author, AuthorRelationReceived, 1); err != nil {
+		log.Printf("Relation: failed to record author stat %v", err)
+	}
+}
+
+// PullRelationStatus returns, for the object IDs of the queryName query
+// parameter, the links the verified user holds on them and the counts per
+// object, relation and weighted state. Anonymous readers only get the counts.
+func PullRelationStatus[T matter.Detectable, PT relationLink[T]](queryName string) HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Relation Status Session missing Response structure")
+		}
+
+		values := s.URLQuery()[queryName]
+		if len(values) == 0 {
+			return nil
+		}
+
+		if len(values) > MAX_QUERY_IN_SIZE {
+			return NewStatusString("too many ids in query", http.StatusBadRequest)
+		}
+
+		predicates, err := ExpressFilter(s.RequestContext, Fld("objid").ByIDSetFromQuery(queryName))
+		if err != nil {
+			return err
+		}
+
+		ids, err := convertStringToIDs(values)
+		if err != nil {
+			return NewStatusString("invalid id from query", http.StatusBadRequest)
+		}
+
+		var objectIds []bson.ObjectID
+		for _, id := range ids {
+			if !slices.Contains(objectIds, id) {
+				objectIds = append(objectIds, id)
+			}
+		}
+
+		ctx := s.Request.Context()
+		session, err := s.VerifySession()
+		if err == nil && session != nil && !session.UserId.IsZero() {
+			links, err := km.Detect[T](
+				append(predicates, km.Fld("subjid").Eq(session.UserId))...,
+			).Limit(int64(len(objectIds)) * MAX_LINKS_PER_OBJECT).PullAll(ctx)
+			if err != nil {
+				return fmt.Errorf("Relation Status request error: %v", err)
+			}
+
+			for _, link := range links {
+				if PT(&link).Link().Name == "" {
+					s.Response.Append(link)
+				}
+			}
+		}
+
+		var zero T
+		counts, err := relationCounts[T](ctx, PT(&zero).ObjectKind(), objectIds)
+		if err != nil {
+			return fmt.Errorf("Relation count request error: %v", err)
+		}
+
+		for _, objectId := range objectIds {
+			for _, count := range counts[objectId] {
+				s.Response.Append(count)
+			}
+		}
+		return nil
+	}
+}
@@ -7,6 +7,7 @@ import (
 type RelationTopicResponse struct {
 	EntangledResponse
 	LinkDescs []LinkDescription `xml:"-" json:"linkdescs,omitempty" bson:"-" `
+	Counts    []RelationCount   `xml:"-" json:"counts,omitempty" bson:"-" `
 }
 
 func NewRelationTopicResponse() Response {
@@ -27,6 +28,24 @@ func (rr *RelationTopicResponse) Append(data any) bson.ObjectID {
 	case *UserToCommentLink:
 		rr.LinkDescs = append(rr.LinkDescs, response.LinkDescription)
 		return response.ObjectId
+	case UserToRootLink:
+		rr.LinkDescs = append(rr.LinkDescs, response.LinkDescription)
+		return response.ObjectId
+	case *UserToRootLink:
+		rr.LinkDescs = append(rr.LinkDescs, response.LinkDescription)
+		return response.ObjectId
+	case UserToAuthorLink:
+		rr.LinkDescs = append(rr.LinkDescs, response.LinkDescription)
+		return response.ObjectId
+	case *UserToAuthorLink:
+		rr.LinkDescs = append(rr.LinkDescs, response.LinkDescription)
+		return response.ObjectId
+	case RelationCount:
+		rr.Counts = append(rr.Counts, response)
+		return response.ObjectId
+	case *RelationCount:
+		rr.Counts = append(rr.Counts, *response)
+		return response.ObjectId
 	}
 
 	return rr.BaseResponse.Append(data)
@@ -0,0 +1,201 @@
+package topic
+
+import (
+	"cmp"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+)
+
+const (
+	KindUser    = "user"
+	KindPage    = "page"
+	KindComment = "comment"
+	KindRoot    = "root"
+	KindAuthor  = "author"
+)
+
+const (
+	RelationBookmarked = "bookmarked"
+	RelationEndorsed   = "endorsed"
+	RelationFollowing  = "following"
+	RelationReported   = "reported"
+)
+
+// RelationType describes a relation a subject may hold on an object. A link is
+// created in InitialState and may then only move along Transitions. Links
+// count, with Weight, while in one of WeightedStates, or in InitialState when
+// none is given.
+type RelationType struct {
+	Name           string              `json:"name"`
+	SubjectKind    string              `json:"subjectkind"`
+	ObjectKind     string              `json:"objectkind"`
+	States         []string            `json:"states"`
+	InitialState   string              `json:"initialstate"`
+	Transitions    map[string][]string `json:"transitions"`
+	WeightedStates []string            `json:"weightedstates,omitempty"`
+	Weight         float64             `json:"weight"`
+	Label          string              `json:"label,omitempty"`
+	Description    string              `json:"description,omitempty"`
+}
+
+// toggleTransitions lets a link be switched off and on again any number of times.
+var toggleTransitions = map[string][]string{
+	RelationActive:   {RelationInactive},
+	RelationInactive: {RelationActive},
+}
+
+func NewToggleRelation(subjectKind string, objectKind string, name string, weight float64, label string) RelationType {
+	return RelationType{
+		Name:         name,
+		SubjectKind:  subjectKind,
+		ObjectKind:   objectKind,
+		States:       []string{RelationActive, RelationInactive},
+		InitialState: RelationActive,
+		Transitions:  toggleTransitions,
+		Weight:       weight,
+		Label:        label,
+	}
+}
+
+func (r RelationType) HasState(state string) bool {
+	return slices.Contains(r.States, state)
+}
+
+// CarriesWeight reports whether links in state are counted.
+func (r RelationType) CarriesWeight(state string) bool {
+	if len(r.WeightedStates) == 0 {
+		return state == r.InitialState
+	}
+	return slices.Contains(r.WeightedStates, state)
+}
+
+// CanTransition reports whether a link may move from one state to another. An
+// empty from state is the creation of the link.
+func (r RelationType) CanTransition(from string, to string) bool {
+	if from == "" {
+		return to == r.InitialState
+	}
+	if from == to {
+		return r.HasState(to)
+	}
+	return slices.Contains(r.Transitions[from], to)
+}
+
+func (r RelationType) Validate() error {
+	if r.Name == "" || r.SubjectKind == "" || r.ObjectKind == "" {
+		return fmt.Errorf("relation type missing name or kinds")
+	}
+
+	if !r.HasState(r.InitialState) {
+		return fmt.Errorf("relation %s: initial state %q is not a state", r.Name, r.InitialState)
+	}
+
+	for _, state := range r.WeightedStates {
+		if !r.HasState(state) {
+			return fmt.Errorf("relation %s: weighted state %q is not a state", r.Name, state)
+		}
+	}
+
+	for from, targets := range r.Transitions {
+		if !r.HasState(from) {
+			return fmt.Errorf("relation %s: transition from unknown state %q", r.Name, from)
+		}
+		for _, to := range targets {
+			if !r.HasState(to) {
+				return fmt.Errorf("relation %s: transition to unknown state %q", r.Name, to)
+			}
+		}
+	}
+	return nil
+}
+
+type relationKey struct {
+	subjectKind string
+	objectKind  string
+	name        string
+}
+
+var relationMutex sync.RWMutex
+var relationTypes = map[relationKey]RelationType{}
+
+func init() {
+	for _, relation := range []RelationType{
+		NewToggleRelation(KindUser, KindPage, RelationBookmarked, 2.0, "Bookmark"),
+		NewToggleRelation(KindUser, KindPage, RelationEndorsed, 1.5, "Endorse"),
+		NewToggleRelation(KindUser, KindComment, CommentUpvote, 1.0, "Upvote"),
+		NewToggleRelation(KindUser, KindComment, CommentDownvote, -1.0, "Downvote"),
+		NewToggleRelation(KindUser, KindComment, RelationReported, 0, "Report"),
+		NewToggleRelation(KindUser, KindRoot, RelationFollowing, 0, "Follow"),
+		NewToggleRelation(KindUser, KindAuthor, RelationFollowing, 0, "Follow"),
+	} {
+		if err := RegisterRelation(relation); err != nil {
+			panic(err)
+		}
+	}
+}
+
+func RegisterRelation(relation RelationType) error {
+	if err := relation.Validate(); err != nil {
+		return err
+	}
+
+	relationMutex.Lock()
+	defer relationMutex.Unlock()
+	relationTypes[relationKey{relation.SubjectKind, relation.ObjectKind, relation.Name}] = relation
+	return nil
+}
+
+func LookupRelation(subjectKind string, objectKind string, name string) (RelationType, bool) {
+	relationMutex.RLock()
+	defer relationMutex.RUnlock()
+	relation, ok := relationTypes[relationKey{subjectKind, objectKind, name}]
+	return relation, ok
+}
+
+// relationCarriesWeight asks the registry whether user links in state are
+// counted. Links of unregistered relations count while active.
+func relationCarriesWeight(objectKind string, relation string, state string) bool {
+	if relationType, ok := LookupRelation(KindUser, objectKind, relation); ok {
+		return relationType.CarriesWeight(state)
+	}
+	return state == RelationActive
+}
+
+// RelationsOf lists the relation types a subject kind may hold on an object kind.
+func RelationsOf(subjectKind string, objectKind string) []RelationType {
+	relationMutex.RLock()
+	defer relationMutex.RUnlock()
+
+	var retval []RelationType
+	for key, relation := range relationTypes {
+		if key.subjectKind == subjectKind && key.objectKind == objectKind {
+			retval = append(retval, relation)
+		}
+	}
+
+	slices.SortFunc(retval, func(a, b RelationType) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return retval
+}
+
+// ValidateLink checks a link write against the registry: the relation must be
+// registered for the kinds and move from the previous state (empty when the
+// link is new) to its new state along an allowed transition.
+func ValidateLink(subjectKind string, objectKind string, link LinkDescription, previousState string) error {
+	relation, ok := LookupRelation(subjectKind, objectKind, link.Relation)
+	if !ok {
+		return NewStatusString("unknown relation", http.StatusBadRequest)
+	}
+
+	if !relation.HasState(link.State) {
+		return NewStatusString("invalid relation state", http.StatusBadRequest)
+	}
+
+	if !relation.CanTransition(previousState, link.State) {
+		return NewStatusString(fmt.Sprintf("relation %s cannot move from %q to %q", link.Relation, previousState, link.State), http.StatusConflict)
+	}
+	return nil
+}
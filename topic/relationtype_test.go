@@ -0,0 +1,69 @@
+package topic
+
+import (
+	"testing"
+)
+
+func TestRelationRegistry(t *testing.T) {
+	bookmark, ok := LookupRelation(KindUser, KindPage, RelationBookmarked)
+	if !ok {
+		t.Fatalf("expected bookmarked relation to be registered")
+	}
+
+	if !bookmark.CanTransition("", RelationActive) || bookmark.CanTransition("", RelationInactive) {
+		t.Errorf("expected links to be created active")
+	}
+	if !bookmark.CanTransition(RelationActive, RelationInactive) || !bookmark.CanTransition(RelationInactive, RelationActive) {
+		t.Errorf("expected bookmark to toggle")
+	}
+
+	link := LinkDescription{Relation: RelationBookmarked, State: RelationInactive}
+	if err := ValidateLink(KindUser, KindPage, link, RelationActive); err != nil {
+		t.Errorf("expected valid transition, got %v", err)
+	}
+	if err := ValidateLink(KindUser, KindPage, link, ""); err == nil {
+		t.Errorf("expected new inactive link to be rejected")
+	}
+	if err := ValidateLink(KindUser, KindComment, link, RelationActive); err == nil {
+		t.Errorf("expected bookmark on comment to be rejected")
+	}
+
+	watched := RelationType{
+		Name:           "watched",
+		SubjectKind:    KindUser,
+		ObjectKind:     KindPage,
+		States:         []string{"watching", "done"},
+		InitialState:   "watching",
+		Transitions:    map[string][]string{"watching": {"done"}},
+		WeightedStates: []string{"watching", "done"},
+		Weight:         0.5,
+	}
+	t.Cleanup(func() {
+		relationMutex.Lock()
+		defer relationMutex.Unlock()
+		delete(relationTypes, relationKey{KindUser, KindPage, "watched"})
+	})
+	if err := RegisterRelation(watched); err != nil {
+		t.Fatalf("RegisterRelation failed: %v", err)
+	}
+	if err := ValidateLink(KindUser, KindPage, LinkDescription{Relation: "watched", State: "watching"}, "done"); err == nil {
+		t.Errorf("expected one way transition to be enforced")
+	}
+
+	if !relationCarriesWeight(KindPage, "watched", "done") || relationCarriesWeight(KindPage, RelationBookmarked, RelationInactive) {
+		t.Errorf("expected the registry to decide which states are counted")
+	}
+	if !relationCarriesWeight(KindPage, "other", RelationActive) || relationCarriesWeight(KindPage, "other", RelationInactive) {
+		t.Errorf("expected unregistered relations to count while active")
+	}
+
+	counts := []RelationCount{{Relation: "watched", State: "done", Count: 2}}
+	if score := DefaultScorer.RelationScore(counts); score != 1.0 {
+		t.Errorf("expected registered weight to apply, got %v", score)
+	}
+
+	watched.Transitions = map[string][]string{"watching": {"gone"}}
+	if err := RegisterRelation(watched); err == nil {
+		t.Errorf("expected transition to unknown state to be rejected")
+	}
+}
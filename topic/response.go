@@ -59,12 +59,17 @@ func (e StatusResponse) HasError() bool {
 }
 
 type BaseResponse struct {
-	TargetID     bson.ObjectID      `json:"TargetId,omitempty,omitzero" `
-	PageData     []Page             `json:"PageData,omitempty" `
-	PagestatData []PageStat         `json:"PagestatData,omitempty" `
-	StanzaData   []Stanza           `json:"StanzaData,omitempty" `
-	CommentData  []Comment          `json:"CommentData,omitempty" `
-	BundleData   []sitepages.Bundle `json:"BundleData,omitempty" `
+	TargetID        bson.ObjectID      `json:"TargetId,omitempty,omitzero" `
+	PageData        []Page             `json:"PageData,omitempty" `
+	PagestatData    []PageStat         `json:"PagestatData,omitempty" `
+	VersionstatData []VersionStat      `json:"VersionstatData,omitempty" `
+	AuthorstatData  []AuthorStat       `json:"AuthorstatData,omitempty" `
+	ReadinglistData []ReadingList      `json:"ReadinglistData,omitempty" `
+	ListitemData    []ReadingListItem  `json:"ListitemData,omitempty" `
+	StanzaData      []Stanza           `json:"StanzaData,omitempty" `
+	CommentData     []Comment          `json:"CommentData,omitempty" `
+	RevisionData    []CommentRevision  `json:"RevisionData,omitempty" `
+	BundleData      []sitepages.Bundle `json:"BundleData,omitempty" `
 }
 
 func (br *BaseResponse) SetTargetID(id bson.ObjectID) {
@@ -116,12 +121,27 @@ func (br *BaseResponse) Append(data any) bson.ObjectID {
 	case PageStat:
 		br.PagestatData = append(br.PagestatData, response)
 		id = response.ID
+	case VersionStat:
+		br.VersionstatData = append(br.VersionstatData, response)
+		id = response.ID
+	case AuthorStat:
+		br.AuthorstatData = append(br.AuthorstatData, response)
+		id = response.ID
+	case ReadingList:
+		br.ReadinglistData = append(br.ReadinglistData, response)
+		id = response.ID
+	case ReadingListItem:
+		br.ListitemData = append(br.ListitemData, response)
+		id = response.Root
 	case Stanza:
 		br.StanzaData = append(br.StanzaData, response)
 		id = response.ID
 	case Comment:
 		br.CommentData = append(br.CommentData, response)
 		id = response.ID
+	case CommentRevision:
+		br.RevisionData = append(br.RevisionData, response)
+		id = response.ID
 	case sitepages.Bundle:
 		br.BundleData = append(br.BundleData, response)
 		id = response.ID
@@ -134,12 +154,27 @@ func (br *BaseResponse) Append(data any) bson.ObjectID {
 	case *Comment:
 		br.CommentData = append(br.CommentData, *response)
 		id = response.ID
+	case *CommentRevision:
+		br.RevisionData = append(br.RevisionData, *response)
+		id = response.ID
 	case *sitepages.Bundle:
 		br.BundleData = append(br.BundleData, *response)
 		id = response.ID
 	case *PageStat:
 		br.PagestatData = append(br.PagestatData, *response)
 		id = response.ID
+	case *VersionStat:
+		br.VersionstatData = append(br.VersionstatData, *response)
+		id = response.ID
+	case *AuthorStat:
+		br.AuthorstatData = append(br.AuthorstatData, *response)
+		id = response.ID
+	case *ReadingList:
+		br.ReadinglistData = append(br.ReadinglistData, *response)
+		id = response.ID
+	case *ReadingListItem:
+		br.ListitemData = append(br.ListitemData, *response)
+		id = response.Root
 	default:
 		log.Printf("Append unknown type: %T", data)
 	}
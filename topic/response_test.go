@@ -188,6 +188,11 @@ func TestRelationTopicResponse(t *testing.T) {
 		t.Errorf("expected link descriptions to be appended")
 	}
 
+	count := RelationCount{ObjectId: linkDesc.ObjectId, Relation: RelationBookmarked, Count: 2}
+	if ret := resp.Append(count); ret != linkDesc.ObjectId || len(resp.Counts) != 1 || len(resp.LinkDescs) != 4 {
+		t.Errorf("expected relation count to be appended to counts")
+	}
+
 	// Test fallback to BaseResponse
 	id := bson.NewObjectID()
 	page := Page{}
@@ -0,0 +1,171 @@
+package topic
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Scorer ranks roots by their PageStat and page versions by the relations
+// users hold on them.
+type Scorer interface {
+	StatScore(stat PageStat, now time.Time) float64
+	RelationScore(counts []RelationCount) float64
+}
+
+// WeightedScorer is a configurable Scorer. Activity is decayed exponentially
+// with the given half-life (no decay when zero) and stats older than the
+// window are dropped (no window when zero).
+type WeightedScorer struct {
+	CommentWeight         float64            `json:"commentweight"`
+	AuthorWeight          float64            `json:"authorweight"`
+	ViewWeight            float64            `json:"viewweight,omitempty"`
+	HalfLifeHours         float64            `json:"halflifehours,omitempty"`
+	WindowHours           float64            `json:"windowhours,omitempty"`
+	RelationWeights       map[string]float64 `json:"relationweights,omitempty"`
+	DefaultRelationWeight float64            `json:"defaultrelationweight"`
+}
+
+func (w WeightedScorer) Window() time.Duration {
+	return time.Duration(w.WindowHours * float64(time.Hour))
+}
+
+func (w WeightedScorer) StatScore(stat PageStat, now time.Time) float64 {
+	if w.WindowHours > 0 && now.Sub(stat.UpdatedTime) > w.Window() {
+		return 0
+	}
+
+	maxScore := w.CommentWeight*float64(stat.CommentCount) + w.AuthorWeight*float64(len(stat.Authors)) + w.ViewWeight*float64(stat.ViewCount)
+
+	// Guard against negative age (clock skew or future dates)
+	if w.HalfLifeHours <= 0 || stat.UpdatedTime.After(now) {
+		return maxScore
+	}
+
+	ageHours := now.Sub(stat.UpdatedTime).Hours()
+
+	// Calculate lambda: ln(2) / half_life
+	lambda := math.Ln2 / w.HalfLifeHours
+	// Calculate exponential decay multiplier: e^(-lambda * age)
+	decayMultiplier := math.Exp(-lambda * ageHours)
+	return maxScore * decayMultiplier
+}
+
+// RelationWeight of a page relation: the scorer's own weight, else the weight
+// of the registered relation type, else DefaultRelationWeight.
+func (w WeightedScorer) RelationWeight(relation string) float64 {
+	if weight, ok := w.RelationWeights[relation]; ok {
+		return weight
+	}
+	if relationType, ok := LookupRelation(KindUser, KindPage, relation); ok {
+		return relationType.Weight
+	}
+	return w.DefaultRelationWeight
+}
+
+func (w WeightedScorer) RelationScore(counts []RelationCount) float64 {
+	score := 0.0
+	for _, relation := range counts {
+		score += w.RelationWeight(relation.Relation) * float64(relation.Count)
+	}
+	return score
+}
+
+// DEFAULT_FEED names the registered scorer of every ranking that does not
+// select a feed.
+var DEFAULT_FEED = "hot"
+
+// DefaultScorer is the initial scorer of the default feed; rankings resolve
+// the feed through the registry, so LoadScorers can retune it.
+var DefaultScorer = WeightedScorer{
+	CommentWeight:         1.0,
+	AuthorWeight:          2.0,
+	HalfLifeHours:         24,
+	DefaultRelationWeight: 1.0,
+}
+
+var scorerMutex sync.RWMutex
+var scorers = map[string]Scorer{
+	"hot": DefaultScorer,
+	"top": WeightedScorer{
+		CommentWeight:         1.0,
+		AuthorWeight:          2.0,
+		ViewWeight:            0.05,
+		WindowHours:           7 * 24,
+		DefaultRelationWeight: 1.0,
+	},
+	"evergreen": WeightedScorer{
+		CommentWeight:         0.5,
+		AuthorWeight:          3.0,
+		ViewWeight:            0.1,
+		HalfLifeHours:         30 * 24,
+		RelationWeights:       map[string]float64{"bookmarked": 3.0, "endorsed": 2.0},
+		DefaultRelationWeight: 1.0,
+	},
+}
+
+func RegisterScorer(name string, scorer Scorer) {
+	scorerMutex.Lock()
+	defer scorerMutex.Unlock()
+	scorers[name] = scorer
+}
+
+func LookupScorer(name string) (Scorer, bool) {
+	scorerMutex.RLock()
+	defer scorerMutex.RUnlock()
+	scorer, ok := scorers[name]
+	return scorer, ok
+}
+
+// FeedScorer resolves the scorer registered for the feed, or for DEFAULT_FEED
+// when name is empty.
+func FeedScorer(name string) (Scorer, bool) {
+	if name == "" {
+		name = DEFAULT_FEED
+	}
+	return LookupScorer(name)
+}
+
+// defaultFeedScorer is the scorer of DEFAULT_FEED, falling back to
+// DefaultScorer when the feed was unregistered.
+func defaultFeedScorer() Scorer {
+	if scorer, ok := FeedScorer(""); ok {
+		return scorer
+	}
+	return DefaultScorer
+}
+
+// LoadScorers registers the WeightedScorers of a JSON object keyed by feed
+// name, e.g. {"hot": {"commentweight": 1, "authorweight": 2, "halflifehours": 12}}.
+// Feeds not present in the document keep their current scorer.
+func LoadScorers(r io.Reader) error {
+	var config map[string]WeightedScorer
+	if err := json.NewDecoder(r).Decode(&config); err != nil {
+		return fmt.Errorf("LoadScorers: %v", err)
+	}
+
+	for name, scorer := range config {
+		if scorer.HalfLifeHours < 0 || scorer.WindowHours < 0 {
+			return fmt.Errorf("LoadScorers: negative duration for feed %s", name)
+		}
+	}
+
+	for name, scorer := range config {
+		RegisterScorer(name, scorer)
+	}
+	return nil
+}
+
+// ScorerFromQuery selects the scorer named by the "feed" query parameter,
+// defaulting to DEFAULT_FEED.
+func ScorerFromQuery(s *RequestContext) (Scorer, error) {
+	scorer, ok := FeedScorer(s.URLQuery().Get("feed"))
+	if !ok {
+		return nil, NewStatusString("unknown feed", http.StatusBadRequest)
+	}
+	return scorer, nil
+}
@@ -0,0 +1,80 @@
+package topic
+
+import (
+	"maps"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWeightedScorer(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	stat := PageStat{CommentCount: 3, Authors: []string{"a", "b"}}
+	stat.UpdatedTime = now.Add(-24 * time.Hour)
+
+	hot, _ := LookupScorer("hot")
+	if score := hot.StatScore(stat, now); math.Abs(score-3.5) > 1e-9 {
+		t.Errorf("expected one half-life of decay on 7.0, got %v", score)
+	}
+
+	top, _ := LookupScorer("top")
+	if score := top.StatScore(stat, now); score != 7.0 {
+		t.Errorf("expected undecayed score within window, got %v", score)
+	}
+
+	stat.UpdatedTime = now.Add(-8 * 24 * time.Hour)
+	if score := top.StatScore(stat, now); score != 0 {
+		t.Errorf("expected zero score outside window, got %v", score)
+	}
+
+	counts := []RelationCount{{Relation: "bookmarked", Count: 1}, {Relation: "endorsed", Count: 1}, {Relation: "other", Count: 1}}
+	if score := DefaultScorer.RelationScore(counts); score != 4.5 {
+		t.Errorf("expected default relation weights to sum to 4.5, got %v", score)
+	}
+
+	counts[0].Count = 3
+	if score := DefaultScorer.RelationScore(counts); score != 8.5 {
+		t.Errorf("expected relation weight to scale with count, got %v", score)
+	}
+}
+
+func TestLoadScorers(t *testing.T) {
+	scorerMutex.RLock()
+	registered := maps.Clone(scorers)
+	scorerMutex.RUnlock()
+	t.Cleanup(func() {
+		scorerMutex.Lock()
+		defer scorerMutex.Unlock()
+		scorers = registered
+	})
+
+	config := `{"rising": {"commentweight": 2, "authorweight": 1, "halflifehours": 6}}`
+	if err := LoadScorers(strings.NewReader(config)); err != nil {
+		t.Fatalf("LoadScorers failed: %v", err)
+	}
+
+	scorer, ok := LookupScorer("rising")
+	if !ok {
+		t.Fatalf("expected rising feed to be registered")
+	}
+
+	weighted := scorer.(WeightedScorer)
+	if weighted.CommentWeight != 2 || weighted.HalfLifeHours != 6 {
+		t.Errorf("unexpected loaded scorer %+v", weighted)
+	}
+
+	if err := LoadScorers(strings.NewReader(`{"bad": {"halflifehours": -1}}`)); err == nil {
+		t.Errorf("expected negative half-life to be rejected")
+	}
+
+	stat := PageStat{CommentCount: 3}
+	stat.UpdatedTime = time.Now()
+	before := stat.FreshnessScore()
+	if err := LoadScorers(strings.NewReader(`{"hot": {"commentweight": 10}}`)); err != nil {
+		t.Fatalf("LoadScorers failed: %v", err)
+	}
+	if after := stat.FreshnessScore(); after == before || after != 30 {
+		t.Errorf("expected the loaded hot scorer to rank the default feed, got %v then %v", before, after)
+	}
+}
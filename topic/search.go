@@ -0,0 +1,46 @@
+package topic
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/borghives/sitepages/search"
+)
+
+var MAX_SEARCH_QUERY_LENGTH = 255
+
+// SearchIndex receives the latest version of a root whenever a page is
+// recorded. Search is disabled while it is nil.
+var SearchIndex search.Index
+
+func SearchPages(limit int) HandlerFunc[Page] {
+	return func(s *Session[Page]) error {
+		if SearchIndex == nil {
+			return NewStatusString("search unavailable", http.StatusServiceUnavailable)
+		}
+
+		if s.Response == nil {
+			return fmt.Errorf("Search Session missing Response structure")
+		}
+
+		text := strings.TrimSpace(s.URLQuery().Get("q"))
+		if text == "" {
+			return NewStatusString("missing search query", http.StatusBadRequest)
+		}
+
+		if len(text) > MAX_SEARCH_QUERY_LENGTH {
+			return NewStatusString("search query too long", http.StatusBadRequest)
+		}
+
+		results, err := SearchIndex.Search(s.Request.Context(), search.Query{Text: text, Limit: limit})
+		if err != nil {
+			return fmt.Errorf("SearchPages request error: %v", err)
+		}
+
+		for _, result := range results {
+			s.Response.Append(result)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,28 @@
+package topic
+
+import (
+	"github.com/borghives/sitepages/search"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+type SearchTopicResponse struct {
+	EntangledResponse
+	SearchData []search.Result `xml:"-" json:"SearchData,omitempty" bson:"-" `
+}
+
+func NewSearchTopicResponse() Response {
+	return &SearchTopicResponse{}
+}
+
+func (sr *SearchTopicResponse) Append(data any) bson.ObjectID {
+	switch response := data.(type) {
+	case search.Result:
+		sr.SearchData = append(sr.SearchData, response)
+		return response.Root
+	case *search.Result:
+		sr.SearchData = append(sr.SearchData, *response)
+		return response.Root
+	}
+
+	return sr.EntangledResponse.Append(data)
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"net/http"
 	"net/url"
+	"slices"
 
 	"git.mypierian.com/borghives/entanglement"
 	"git.mypierian.com/borghives/kosmos-go"
@@ -24,6 +25,9 @@ type RequestContext struct {
 	urlQuery       *url.Values
 	userSession    *websession.Session
 	userSessionErr error
+	viewerResolved bool
+	viewerName     string
+	viewerIsMod    bool
 }
 
 func NewRequestContext(r *http.Request) *RequestContext {
@@ -52,6 +56,55 @@ func (rs *RequestContext) VerifySession() (*websession.Session, error) {
 	return rs.userSession, rs.userSessionErr
 }
 
+// RequireUser returns the verified session of an authenticated user.
+func (rs *RequestContext) RequireUser() (*websession.Session, error) {
+	session, err := rs.VerifySession()
+	if err != nil {
+		return nil, NewStatusError(err, http.StatusExpectationFailed)
+	}
+
+	if session == nil || session.UserId.IsZero() {
+		return nil, NewStatusString("No User", http.StatusUnauthorized)
+	}
+	return session, nil
+}
+
+// IsModerator reports whether the verified user may moderate.
+func (rs *RequestContext) IsModerator() bool {
+	session, err := rs.VerifySession()
+	if err != nil || session == nil || session.UserId.IsZero() {
+		return false
+	}
+	return IsModerator != nil && IsModerator(session)
+}
+
+// Viewer returns the name of the verified user and whether they may moderate,
+// resolved once per request. Both are zero for anonymous requests.
+func (rs *RequestContext) Viewer() (string, bool) {
+	if !rs.viewerResolved {
+		rs.viewerResolved = true
+		session, err := rs.VerifySession()
+		if err == nil && session != nil && !session.UserId.IsZero() {
+			rs.viewerName = session.UserName
+			rs.viewerIsMod = IsModerator != nil && IsModerator(session)
+		}
+	}
+	return rs.viewerName, rs.viewerIsMod
+}
+
+// RequireModerator returns the verified session of a moderator.
+func (rs *RequestContext) RequireModerator() (*websession.Session, error) {
+	session, err := rs.RequireUser()
+	if err != nil {
+		return nil, err
+	}
+
+	if IsModerator == nil || !IsModerator(session) {
+		return nil, NewStatusString("Not a moderator", http.StatusForbidden)
+	}
+	return session, nil
+}
+
 func (rs *RequestContext) HasUserName() bool {
 	if rs.userSessionErr != nil {
 		return false
@@ -132,6 +185,69 @@ func CreateRelationResponse[T matter.Detectable]() HandlerFunc[T] {
 	}
 }
 
+func CreateFacetResponse[T matter.Detectable]() HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			s.Response = NewFacetTopicResponse()
+		}
+		return nil
+	}
+}
+
+func CreateSearchResponse[T matter.Detectable]() HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			s.Response = NewSearchTopicResponse()
+		}
+		return nil
+	}
+}
+
+func CreateRelatedResponse[T matter.Detectable]() HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			s.Response = NewRelatedTopicResponse()
+		}
+		return nil
+	}
+}
+
+func CreateFeedResponse[T matter.Detectable]() HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			s.Response = NewFeedTopicResponse()
+		}
+		return nil
+	}
+}
+
+func CreateThreadResponse[T matter.Detectable]() HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			s.Response = NewThreadResponse()
+		}
+		return nil
+	}
+}
+
+func CreateReconcileResponse[T matter.Detectable]() HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			s.Response = NewReconcileResponse()
+		}
+		return nil
+	}
+}
+
+func CreateNotificationResponse[T matter.Detectable]() HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if s.Response == nil {
+			s.Response = NewNotificationResponse()
+		}
+		return nil
+	}
+}
+
 func CreateListResponse[T matter.Detectable](name string) HandlerFunc[T] {
 	return func(s *Session[T]) error {
 		if s.Response == nil {
@@ -178,6 +294,25 @@ func SetRootIDFromPath[T matter.Detectable]() HandlerFunc[T] {
 	}
 }
 
+// DetectByFilter builds the detector of the session from filters. Models
+// with a VisibleQuery are also restricted in the query to what the user sees.
+func DetectByFilter[T matter.Detectable](filters ...Filter) HandlerFunc[T] {
+	return func(s *Session[T]) error {
+		if visible, ok := any(new(T)).(VisibleQuery); ok {
+			// resolved before the context is copied into the filters
+			s.Viewer()
+			filters = append(slices.Clip(filters), visible.VisibleFilter())
+		}
+
+		predicates, err := ExpressFilter(s.RequestContext, filters...)
+		if err != nil {
+			return err
+		}
+		s.Detector = kosmos.Detect[T](predicates...)
+		return nil
+	}
+}
+
 func Pull[T matter.Detectable](limit int64) HandlerFunc[T] {
 	return func(s *Session[T]) error {
 		if s.Detector == nil {
@@ -212,7 +347,12 @@ func Pull[T matter.Detectable](limit int64) HandlerFunc[T] {
 			s.Response.SetTargetID(*s.TopicId)
 		}
 
+		userName, moderator := s.Viewer()
 		for _, result := range results {
+			if !IsVisibleResult(&result, userName, moderator) {
+				continue
+			}
+
 			err := SanitizeResult(s, &result)
 			if err != nil {
 				return err
@@ -235,6 +375,14 @@ func SanitizeResult[T matter.Detectable](s *Session[T], result *T) error {
 	return nil
 }
 
+func IsVisibleResult[T matter.Detectable](result *T, userName string, moderator bool) bool {
+	visObj, ok := any(result).(Visible)
+	if ok {
+		return visObj.VisibleTo(userName, moderator)
+	}
+	return true
+}
+
 func SetEntanglementFrame[T matter.Detectable](frame string) HandlerFunc[T] {
 	return func(s *Session[T]) error {
 		s.EntangleFrame.SetFrame(frame)
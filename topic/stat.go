@@ -1,15 +1,17 @@
 package topic
 
 import (
+	"bytes"
 	"cmp"
 	"context"
 	"encoding/xml"
-	"math"
+	"fmt"
 	"slices"
 	"time"
 
 	km "git.mypierian.com/borghives/kosmos-go"
 	"git.mypierian.com/borghives/kosmos-go/matter"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
 	"github.com/borghives/sitepages"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
@@ -20,6 +22,7 @@ type PageStat struct {
 	Title        string   `xml:"title" json:"title" bson:"title"`
 	CommentCount int      `xml:"commentcount" json:"commentcount" bson:"comment_count,omitempty"`
 	Authors      []string `xml:"authors" json:"authors" bson:"authors,omitempty"`
+	ViewCount    int      `xml:"viewcount" json:"viewcount" bson:"view_count,omitempty"`
 	commentIncr  int
 	authorsAdd   []string
 }
@@ -29,6 +32,11 @@ func (p *PageStat) IncrCommentCount() {
 	p.CommentCount += 1
 }
 
+func (p *PageStat) DecrCommentCount() {
+	p.commentIncr -= 1
+	p.CommentCount -= 1
+}
+
 func (p *PageStat) AddUniqueAuthor(author string) {
 	if author == "" {
 		return
@@ -43,6 +51,9 @@ func (p *PageStat) AddUniqueAuthor(author string) {
 
 func (p *PageStat) Collapse() matter.Ripple {
 	ripple := p.BaseModel.Collapse()
+	if p.Title != "" {
+		ripple.Set("title", p.Title)
+	}
 	ripple.Set("comment_count", p.CommentCount)
 	ripple.Set("authors", append(p.Authors, p.authorsAdd...))
 
@@ -58,12 +69,22 @@ func (p *PageStat) Collapse() matter.Ripple {
 }
 
 func (p *PageStat) Decohere(ripple matter.Ripple) error {
+	title, ok := ripple.Get("title")
+	if ok {
+		p.Title = title.(string)
+	}
+
 	count, ok := ripple.Get("comment_count")
 	if ok {
 		p.CommentCount = count.(int)
 	}
 	p.commentIncr = 0
 
+	views, ok := ripple.Get("view_count")
+	if ok {
+		p.ViewCount = views.(int)
+	}
+
 	authors, ok := ripple.Get("authors")
 	if ok {
 		p.Authors = authors.([]string)
@@ -81,46 +102,138 @@ func appendUnique(slice []string, element string) []string {
 	return append(slice, element) // Element is new, append and return
 }
 
-func (p PageStat) FreshnessScore() float32 {
-	maxScore := float32(p.CommentCount) + 2.0*float32(len(p.Authors))
+// newestFirst orders page versions and comments by EventAt, then ID, newest
+// first. The first page of a root in this order is its latest version.
+func newestFirst() []expression.SortField {
+	return []expression.SortField{km.Fld("EventAt").Desc(), km.Fld("ID").Desc()}
+}
 
-	now := time.Now()
+// latestVersion is the ID of the latest version of a root.
+type latestVersion struct {
+	Root   bson.ObjectID `bson:"root"`
+	Latest bson.ObjectID `bson:"latest"`
+}
+
+func (r latestVersion) GetID() bson.ObjectID {
+	return bson.NilObjectID
+}
+
+func (r latestVersion) HasID() bool {
+	return false
+}
 
-	// Guard against negative age (clock skew or future dates)
-	if p.UpdatedTime.After(now) {
-		return maxScore
+func (r latestVersion) LastObserved() time.Time {
+	return time.Time{}
+}
+
+// latestVersions picks the latest version of each root, ordered by
+// newestFirst, with a grouping query.
+func latestVersions(ctx context.Context, roots []bson.ObjectID) ([]latestVersion, error) {
+	if len(roots) == 0 {
+		return nil, nil
 	}
 
-	ageHours := now.Sub(p.UpdatedTime).Hours()
+	return km.ProjectInto[latestVersion](
+		km.Fld("root").With().GroupKey("root"),
+		km.Fld("latest").With().One(),
+	).From(
+		km.Detect[Page](
+			km.Fld("Root").ID().In(roots),
+		).Sort(
+			newestFirst()...,
+		).GroupBy(
+			"root",
+		).Accumulate(
+			km.Fld("latest").With().First("_id"),
+		),
+	).PullAll(ctx)
+}
 
-	// Calculate lambda: ln(2) / half_life
-	halfLifeHours := 24.0
-	lambda := math.Ln2 / halfLifeHours
-	// Calculate exponential decay multiplier: e^(-lambda * age)
-	decayMultiplier := math.Exp(-lambda * ageHours)
-	return maxScore * float32(decayMultiplier)
+// isNewerPage orders two page versions in process as newestFirst does.
+func isNewerPage(a sitepages.Page, b sitepages.Page) bool {
+	if !a.EventAt.Equal(b.EventAt) {
+		return a.EventAt.After(b.EventAt)
+	}
+	return bytes.Compare(a.ID[:], b.ID[:]) > 0
+}
 
+// FreshnessScore scores the stat with the scorer of DEFAULT_FEED.
+func (p PageStat) FreshnessScore() float32 {
+	return float32(defaultFeedScorer().StatScore(p, time.Now()))
 }
 
+// TopFreshPageRoot ranks the roots with the scorer of DEFAULT_FEED.
 func TopFreshPageRoot(ctx context.Context) ([]PageStat, error) {
-	pageStats, err := km.Detect[PageStat]().SortLatest().Limit(200).PullAll(ctx)
+	return TopFreshPageRootWith(ctx, defaultFeedScorer())
+}
+
+// TopFreshPageRootWith ranks the most recently updated roots by scorer. Scorers
+// with a window only consider roots updated within it.
+func TopFreshPageRootWith(ctx context.Context, scorer Scorer) ([]PageStat, error) {
+	return cached(ctx, FeedCache, "fresh:"+scorerCacheKey(scorer), []string{cacheFeedTag}, func() ([]PageStat, error) {
+		return topFreshPageRoot(ctx, scorer)
+	})
+}
+
+func topFreshPageRoot(ctx context.Context, scorer Scorer) ([]PageStat, error) {
+	var predicates []expression.QueryFieldPredicate
+	now := time.Now()
+	if windowed, ok := scorer.(interface{ Window() time.Duration }); ok && windowed.Window() > 0 {
+		predicates = append(predicates, km.Fld("UpdatedTime").Gte(now.Add(-windowed.Window())))
+	}
+
+	pageStats, err := km.Detect[PageStat](predicates...).Sort(km.Fld("UpdatedTime").Desc()).Limit(200).PullAll(ctx)
 
 	if err != nil {
 		return nil, err
 	}
 
 	slices.SortFunc(pageStats, func(a, b PageStat) int {
-		scoreA := a.FreshnessScore()
-		scoreB := b.FreshnessScore()
+		scoreA := scorer.StatScore(a, now)
+		scoreB := scorer.StatScore(b, now)
 		return cmp.Compare(scoreB, scoreA)
 	})
 
 	return pageStats, nil
 }
 
+// PullTopPageRoots returns the top roots ranked by the scorer of the "feed"
+// query parameter, DEFAULT_FEED when absent.
+func PullTopPageRoots(limit int) HandlerFunc[PageStat] {
+	return func(s *Session[PageStat]) error {
+		if s.Response == nil {
+			return fmt.Errorf("Top Page Session missing Response structure")
+		}
+
+		scorer, err := ScorerFromQuery(&s.RequestContext)
+		if err != nil {
+			return err
+		}
+
+		pageStats, err := TopFreshPageRootWith(s.Request.Context(), scorer)
+		if err != nil {
+			return fmt.Errorf("TopFreshPageRoot request error: %v", err)
+		}
+
+		if len(pageStats) > limit {
+			pageStats = pageStats[:limit]
+		}
+
+		for _, stat := range pageStats {
+			s.Response.Append(stat)
+		}
+		return nil
+	}
+}
+
+var MAX_VERSIONS_PER_ROOT = 100
+var MAX_RANK_CANDIDATES = 2000
+
 type RelationCount struct {
-	Relation string `bson:"relation"`
-	Count    int    `bson:"count"`
+	ObjectId bson.ObjectID `json:"ObjId,omitzero" bson:"objid,omitempty"`
+	Relation string        `json:"Relation" bson:"relation"`
+	State    string        `json:"State,omitempty" bson:"state,omitempty"`
+	Count    int           `json:"Count" bson:"count"`
 }
 
 func (r RelationCount) GetID() bson.ObjectID {
@@ -135,77 +248,198 @@ func (r RelationCount) LastObserved() time.Time {
 	return time.Time{}
 }
 
-type PageRank struct {
-	sitepages.Page `bson:",inline"`
-	score          float32
-	hasScore       bool
+// PageRelationCounts counts the relations of every page in a single
+// aggregation grouped by page, relation and state. Only the states that carry
+// weight (see RelationType.CarriesWeight) are returned.
+func PageRelationCounts(ctx context.Context, pageIDs []bson.ObjectID) (map[bson.ObjectID][]RelationCount, error) {
+	return relationCounts[UserToPageLink](ctx, KindPage, pageIDs)
 }
 
-func (p *PageRank) LinkScore(ctx context.Context) float32 {
-	if p.hasScore {
-		return p.score
-	}
+func CommentRelationCounts(ctx context.Context, commentIDs []bson.ObjectID) (map[bson.ObjectID][]RelationCount, error) {
+	return relationCounts[UserToCommentLink](ctx, KindComment, commentIDs)
+}
 
-	p.hasScore = true
+func relationCounts[T matter.Detectable](ctx context.Context, objectKind string, objectIDs []bson.ObjectID) (map[bson.ObjectID][]RelationCount, error) {
+	retval := make(map[bson.ObjectID][]RelationCount, len(objectIDs))
+	if len(objectIDs) == 0 {
+		return retval, nil
+	}
 
 	relationCounts, err := km.ProjectInto[RelationCount](
+		km.Fld("objid").With().GroupKey("objid"),
 		km.Fld("relation").With().GroupKey("relation"),
+		km.Fld("state").With().GroupKey("state"),
 		km.Fld("count").With().One(),
 	).From(
-		km.Detect[UserToPageLink](
-			km.Fld("objid").ID().Eq(p.Page.ID),
-			km.Fld("state").Eq("active"),
+		km.Detect[T](
+			km.Fld("objid").ID().In(objectIDs),
 		).GroupBy(
-			"relation",
+			"objid", "relation", "state",
 		).Accumulate(
 			km.Fld("count").With().Sum(1),
 		),
 	).PullAll(ctx)
 
 	if err != nil {
-		return 0.0
+		return nil, err
+	}
+
+	for _, count := range relationCounts {
+		if relationCarriesWeight(objectKind, count.Relation, count.State) {
+			retval[count.ObjectId] = append(retval[count.ObjectId], count)
+		}
+	}
+	return retval, nil
+}
+
+type PageRank struct {
+	sitepages.Page `bson:",inline"`
+	relations      []RelationCount
+	hasRelations   bool
+}
+
+func (p *PageRank) RelationCounts(ctx context.Context) []RelationCount {
+	if p.hasRelations {
+		return p.relations
+	}
+
+	counts, err := PageRelationCounts(ctx, []bson.ObjectID{p.Page.ID})
+	if err != nil {
+		return nil
 	}
 
-	var finalScore float32
+	p.SetRelationCounts(counts[p.Page.ID])
+	return p.relations
+}
+
+func (p *PageRank) SetRelationCounts(counts []RelationCount) {
+	p.relations = counts
+	p.hasRelations = true
+}
+
+// LinkScore scores the relations of the page with the scorer of DEFAULT_FEED.
+func (p *PageRank) LinkScore(ctx context.Context) float32 {
+	return p.LinkScoreWith(ctx, defaultFeedScorer())
+}
 
-	for _, relation := range relationCounts {
-		switch relation.Relation {
-		case "bookmarked":
-			finalScore += 2.0
-		case "endorsed":
-			finalScore += 1.5
-		default:
-			finalScore += 1.0
+func (p *PageRank) LinkScoreWith(ctx context.Context, scorer Scorer) float32 {
+	return float32(scorer.RelationScore(p.RelationCounts(ctx)))
+}
+
+// RankPages loads the relation counts of all pages at once and sorts them by
+// score. Ties keep their incoming order, so latest-first input favours the
+// most recent version.
+func RankPages(ctx context.Context, pages []PageRank, scorer Scorer) error {
+	var pending []bson.ObjectID
+	for _, page := range pages {
+		if !page.hasRelations {
+			pending = append(pending, page.ID)
 		}
 	}
 
-	p.score = finalScore
+	counts, err := PageRelationCounts(ctx, pending)
+	if err != nil {
+		return err
+	}
 
-	return p.score
+	scores := make(map[bson.ObjectID]float64, len(pages))
+	for i := range pages {
+		if !pages[i].hasRelations {
+			pages[i].SetRelationCounts(counts[pages[i].ID])
+		}
+		scores[pages[i].ID] = scorer.RelationScore(pages[i].relations)
+	}
+
+	slices.SortStableFunc(pages, func(a, b PageRank) int {
+		return cmp.Compare(scores[b.ID], scores[a.ID])
+	})
+	return nil
 }
 
+// TopPageByRoot ranks the versions of root with the scorer of DEFAULT_FEED.
 func TopPageByRoot(ctx context.Context, root bson.ObjectID) (*PageRank, error) {
-	pages, err := km.Detect[PageRank](
+	return TopPageByRootWith(ctx, root, defaultFeedScorer())
+}
+
+type cachedPageRank struct {
+	Page      *sitepages.Page `bson:"page,omitempty"`
+	Relations []RelationCount `bson:"relations,omitempty"`
+}
+
+func TopPageByRootWith(ctx context.Context, root bson.ObjectID, scorer Scorer) (*PageRank, error) {
+	entry, err := cached(ctx, FeedCache, "top:"+root.Hex()+":"+scorerCacheKey(scorer), []string{cacheRootTag(root)}, func() (cachedPageRank, error) {
+		page, err := topPageByRoot(ctx, root, scorer)
+		if err != nil || page == nil {
+			return cachedPageRank{}, err
+		}
+		return cachedPageRank{Page: &page.Page, Relations: page.relations}, nil
+	})
+
+	if err != nil || entry.Page == nil {
+		return nil, err
+	}
+
+	page := &PageRank{Page: *entry.Page}
+	page.SetRelationCounts(entry.Relations)
+	return page, nil
+}
+
+// rootVersions pulls the latest limit versions of root, newest first.
+func rootVersions(ctx context.Context, root bson.ObjectID, limit int) ([]PageRank, error) {
+	return km.Detect[PageRank](
 		km.Fld("Root").ID().Eq(root),
-	).SortLatest().Limit(100).PullAll(ctx)
+	).Sort(newestFirst()...).Limit(int64(limit)).PullAll(ctx)
+}
+
+func topPageByRoot(ctx context.Context, root bson.ObjectID, scorer Scorer) (*PageRank, error) {
+	pages, err := rootVersions(ctx, root, MAX_VERSIONS_PER_ROOT)
+	if err != nil {
+		return nil, err
+	}
 
 	if len(pages) == 0 {
 		return nil, nil
 	}
 
-	slices.SortFunc(pages, func(a, b PageRank) int {
-		scoreA := a.LinkScore(ctx)
-		scoreB := b.LinkScore(ctx)
-		return cmp.Compare(scoreB, scoreA)
-	})
-
-	if err != nil {
+	if err := RankPages(ctx, pages, scorer); err != nil {
 		return nil, err
 	}
 
 	return &pages[0], nil
 }
 
+// TopPagesByRoots ranks the latest versions of many roots with a single
+// relation aggregation. Every root gets its share of MAX_RANK_CANDIDATES
+// versions. Roots without pages are absent from the result.
+func TopPagesByRoots(ctx context.Context, roots []bson.ObjectID, scorer Scorer) (map[bson.ObjectID]*PageRank, error) {
+	retval := make(map[bson.ObjectID]*PageRank, len(roots))
+	if len(roots) == 0 {
+		return retval, nil
+	}
+
+	perRoot := max(min(MAX_VERSIONS_PER_ROOT, MAX_RANK_CANDIDATES/len(roots)), 1)
+	var candidates []PageRank
+	for _, root := range roots {
+		pages, err := rootVersions(ctx, root, perRoot)
+		if err != nil {
+			return nil, err
+		}
+		candidates = append(candidates, pages...)
+	}
+
+	if err := RankPages(ctx, candidates, scorer); err != nil {
+		return nil, err
+	}
+
+	// candidates are sorted best first: the first page seen of each root wins
+	for i := range candidates {
+		if _, ok := retval[candidates[i].Root]; !ok {
+			retval[candidates[i].Root] = &candidates[i]
+		}
+	}
+	return retval, nil
+}
+
 // func SelectPagesByRoot(ctx context.Context, roots []bson.ObjectID) (pages []Page, err error) {
 // 	pageStats, err := km.Detect[sitepages.Page](
 // 		km.Fld("Root").ID().In(roots),
@@ -0,0 +1,415 @@
+package topic
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var MAX_THREAD_COMMENTS int64 = 2000
+var MAX_THREAD_DEPTH = 8
+var MAX_THREAD_WIDTH = 50
+
+var DEFAULT_THREAD_DEPTH = 4
+var DEFAULT_THREAD_WIDTH = 10
+
+// CommentNode places a comment in its thread. Replies holds the loaded replies
+// in sibling order; when ReplyCount exceeds them, More continues the branch.
+type CommentNode struct {
+	ID         bson.ObjectID `json:"ID"`
+	Depth      int           `json:"depth"`
+	ReplyCount int           `json:"replycount"`
+	Replies    []CommentNode `json:"replies,omitempty"`
+	More       string        `json:"more,omitempty"`
+}
+
+// threadCursor continues the replies of Parent (zero for the top level) after
+// the sibling last seen, kept by its sort key rather than its position so that
+// comments posted meanwhile do not shift the continuation. A zero ID starts
+// from the first reply.
+type threadCursor struct {
+	Parent  bson.ObjectID
+	Score   float64
+	EventAt time.Time
+	ID      bson.ObjectID
+}
+
+func (c threadCursor) String() string {
+	if c.ID.IsZero() {
+		return c.Parent.Hex()
+	}
+	return fmt.Sprintf("%s_%s_%d_%s", c.Parent.Hex(), strconv.FormatFloat(c.Score, 'g', -1, 64), c.EventAt.UnixNano(), c.ID.Hex())
+}
+
+// cursorAfter is the cursor of the siblings following comment in order.
+func cursorAfter(parent bson.ObjectID, comment Comment, order string) threadCursor {
+	return threadCursor{Parent: parent, Score: commentOrderScore(comment, order), EventAt: comment.EventAt, ID: comment.ID}
+}
+
+// follows reports whether comment comes after the cursor in order.
+func (c threadCursor) follows(comment Comment, order string) bool {
+	if c.ID.IsZero() {
+		return true
+	}
+
+	last := Comment{Score: c.Score, Controversy: c.Score, BestBy: time.UnixMilli(int64(c.Score)).UTC(), EventAt: c.EventAt}
+	last.ID = c.ID
+	return compareComments(last, comment, order) < 0
+}
+
+// siblingPredicates selects the replies of the cursor parent that follow the
+// cursor in order, as follows does in process, so that a continuation reads
+// on from the cursor rather than from the first reply.
+func (c threadCursor) siblingPredicates(order string) []expression.QueryFieldPredicate {
+	predicates := []expression.QueryFieldPredicate{km.Fld("parent").Eq(c.Parent)}
+	if c.ID.IsZero() {
+		return predicates
+	}
+
+	older := km.Or(
+		km.Fld("EventAt").Lt(c.EventAt),
+		km.And(
+			km.Fld("EventAt").Eq(c.EventAt),
+			km.Fld("ID").Lt(c.ID),
+		),
+	)
+
+	var field string
+	var key any
+	switch order {
+	case CommentOrderBest:
+		field, key = "BestBy", time.UnixMilli(int64(c.Score)).UTC()
+	case CommentOrderControversial:
+		field, key = "Controversy", c.Score
+	default:
+		return append(predicates, older)
+	}
+
+	return append(predicates, km.Or(
+		km.Fld(field).Lt(key),
+		km.And(
+			km.Fld(field).Eq(key),
+			older,
+		),
+	))
+}
+
+// pullThreadSiblings reads, in order, up to limit comments matching predicates
+// among the replies the cursor continues.
+var pullThreadSiblings = func(ctx context.Context, cursor threadCursor, order string, limit int64, predicates []expression.QueryFieldPredicate) ([]Comment, error) {
+	return km.Detect[Comment](
+		append(predicates, cursor.siblingPredicates(order)...)...,
+	).Sort(commentSort(order)...).Limit(limit).PullAll(ctx)
+}
+
+func parseThreadCursor(value string) (*threadCursor, error) {
+	parts := strings.Split(value, "_")
+	if len(parts) != 1 && len(parts) != 4 {
+		return nil, fmt.Errorf("malformed thread cursor")
+	}
+
+	parent, err := bson.ObjectIDFromHex(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("malformed thread cursor parent: %v", err)
+	}
+
+	cursor := &threadCursor{Parent: parent}
+	if len(parts) == 1 {
+		return cursor, nil
+	}
+
+	cursor.Score, err = strconv.ParseFloat(parts[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed thread cursor score")
+	}
+
+	nanos, err := strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("malformed thread cursor time")
+	}
+	cursor.EventAt = time.Unix(0, nanos).UTC()
+
+	cursor.ID, err = bson.ObjectIDFromHex(parts[3])
+	if err != nil {
+		return nil, fmt.Errorf("malformed thread cursor id: %v", err)
+	}
+	return cursor, nil
+}
+
+// threadBuilder groups comments by parent. A comment whose parent is not
+// among the comments is left out with its replies rather than moved up, as
+// its place in the thread is unknown.
+type threadBuilder struct {
+	children map[bson.ObjectID][]Comment
+	parents  map[bson.ObjectID]bson.ObjectID
+	order    string
+	depth    int
+	width    int
+	visit    func(Comment)
+}
+
+func newThreadBuilder(comments []Comment, order string, depth int, width int, visit func(Comment)) *threadBuilder {
+	children := make(map[bson.ObjectID][]Comment)
+	parents := make(map[bson.ObjectID]bson.ObjectID, len(comments))
+	for _, comment := range comments {
+		if comment.Parent == comment.ID {
+			continue
+		}
+		children[comment.Parent] = append(children[comment.Parent], comment)
+		parents[comment.ID] = comment.Parent
+	}
+
+	for _, siblings := range children {
+		SortComments(siblings, order)
+	}
+	return &threadBuilder{children: children, parents: parents, order: order, depth: depth, width: width, visit: visit}
+}
+
+// branch builds the replies of parent following the cursor, returning the
+// nodes and the cursor of the remaining siblings.
+func (b *threadBuilder) branch(cursor threadCursor, depth int) ([]CommentNode, string) {
+	siblings := b.children[cursor.Parent]
+	start := slices.IndexFunc(siblings, func(comment Comment) bool {
+		return cursor.follows(comment, b.order)
+	})
+	if start < 0 {
+		return nil, ""
+	}
+
+	end := min(start+b.width, len(siblings))
+	nodes := make([]CommentNode, 0, end-start)
+	for _, comment := range siblings[start:end] {
+		if b.visit != nil {
+			b.visit(comment)
+		}
+
+		node := CommentNode{ID: comment.ID, Depth: depth, ReplyCount: len(b.children[comment.ID])}
+		if node.ReplyCount > 0 {
+			if depth+1 < b.depth {
+				node.Replies, node.More = b.branch(threadCursor{Parent: comment.ID}, depth+1)
+			} else {
+				node.More = threadCursor{Parent: comment.ID}.String()
+			}
+		}
+		nodes = append(nodes, node)
+	}
+
+	if end < len(siblings) {
+		return nodes, cursorAfter(cursor.Parent, siblings[end-1], b.order).String()
+	}
+	return nodes, ""
+}
+
+// BuildCommentThread nests the comments under their parents up to depth levels
+// and width siblings per branch, siblings sorted by order. Starting from a
+// cursor builds only the branch it continues, which is empty when the cursor
+// parent is not in the thread. visit is called, in thread order, with every
+// comment placed in the tree.
+func BuildCommentThread(comments []Comment, order string, depth int, width int, cursor *threadCursor, visit func(Comment)) ([]CommentNode, string) {
+	builder := newThreadBuilder(comments, order, max(depth, 1), max(width, 1), visit)
+	if cursor == nil {
+		return builder.branch(threadCursor{}, 0)
+	}
+
+	start := 0
+	if !cursor.Parent.IsZero() {
+		parentDepth, ok := builder.depthOf(cursor.Parent)
+		if !ok {
+			return nil, ""
+		}
+		start = parentDepth + 1
+	}
+	builder.depth += start
+	return builder.branch(*cursor, start)
+}
+
+// depthOf is the depth of the comment id, if its ancestors reach the top level.
+func (b *threadBuilder) depthOf(id bson.ObjectID) (int, bool) {
+	depth := 0
+	for current := id; depth <= len(b.parents); depth++ {
+		parent, ok := b.parents[current]
+		if !ok {
+			return 0, false
+		}
+		if parent.IsZero() {
+			return depth, true
+		}
+		current = parent
+	}
+	return 0, false
+}
+
+// completeThread adds to the comments their missing ancestors, then the
+// replies of every comment level by level, up to depth levels and budget
+// comments, sorted by order within budget. Only comments shown to the user are
+// pulled; replies to a comment they cannot see are left out of the thread.
+func completeThread(ctx context.Context, comments []Comment, order string, depth int, budget int64, userName string, moderator bool) ([]Comment, error) {
+	visibility := commentsVisibleTo(userName, moderator)
+	known := make(map[bson.ObjectID]bool, len(comments))
+	var retval []Comment
+	add := func(batch []Comment) []bson.ObjectID {
+		var added []bson.ObjectID
+		for _, comment := range batch {
+			if known[comment.ID] {
+				continue
+			}
+			known[comment.ID] = true
+			if !comment.VisibleTo(userName, moderator) {
+				continue
+			}
+			retval = append(retval, comment)
+			added = append(added, comment.ID)
+		}
+		return added
+	}
+	add(comments)
+
+	// every round marks the missing parents known, so the walk ends
+	for int64(len(known)) < MAX_THREAD_COMMENTS {
+		var missing []bson.ObjectID
+		for _, comment := range retval {
+			if !comment.Parent.IsZero() && !known[comment.Parent] && !slices.Contains(missing, comment.Parent) {
+				missing = append(missing, comment.Parent)
+			}
+		}
+		if len(missing) == 0 {
+			break
+		}
+
+		ancestors, err := km.Detect[Comment](
+			append(visibility, km.Fld("ID").In(missing))...,
+		).Limit(int64(len(missing))).PullAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range missing {
+			known[id] = true
+		}
+		add(ancestors)
+	}
+
+	frontier := make([]bson.ObjectID, 0, len(retval))
+	for _, comment := range retval {
+		frontier = append(frontier, comment.ID)
+	}
+
+	for level := 0; level < depth && len(frontier) > 0 && int64(len(retval)) < budget; level++ {
+		replies, err := km.Detect[Comment](
+			append(visibility, km.Fld("parent").In(frontier))...,
+		).Sort(commentSort(order)...).Limit(budget - int64(len(retval))).PullAll(ctx)
+		if err != nil {
+			return nil, err
+		}
+		frontier = add(replies)
+	}
+	return retval, nil
+}
+
+func threadParam(s *RequestContext, name string, fallback int, limit int) (int, error) {
+	value := s.URLQuery().Get(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, NewStatusString("invalid "+name, http.StatusBadRequest)
+	}
+	return min(n, limit), nil
+}
+
+// PullCommentThread pulls comments like Pull and returns them nested in a
+// thread. The "sort" query parameter orders siblings, "depth" and "width"
+// bound the tree and "more" continues a branch from the cursor of a previous
+// response; continuing the top level needs the root of the session (see
+// SetRootIDFromPath). The comments of the tree are also returned in
+// CommentData.
+func PullCommentThread(limit int64) HandlerFunc[Comment] {
+	return func(s *Session[Comment]) error {
+		if s.Detector == nil {
+			return fmt.Errorf("Comment Thread Session missing Detector")
+		}
+
+		if s.Response == nil {
+			return fmt.Errorf("Comment Thread Session missing Response structure")
+		}
+
+		order, err := CommentOrderFromQuery(&s.RequestContext)
+		if err != nil {
+			return err
+		}
+
+		depth, err := threadParam(&s.RequestContext, "depth", DEFAULT_THREAD_DEPTH, MAX_THREAD_DEPTH)
+		if err != nil {
+			return err
+		}
+
+		width, err := threadParam(&s.RequestContext, "width", DEFAULT_THREAD_WIDTH, MAX_THREAD_WIDTH)
+		if err != nil {
+			return err
+		}
+
+		var cursor *threadCursor
+		if value := s.URLQuery().Get("more"); value != "" {
+			cursor, err = parseThreadCursor(value)
+			if err != nil {
+				return NewStatusError(err, http.StatusBadRequest)
+			}
+		}
+
+		// sorted before the limit, then completed into whole branches
+		ctx := s.Request.Context()
+		budget := min(limit, MAX_THREAD_COMMENTS)
+		userName, moderator := s.Viewer()
+		var results []Comment
+		if cursor == nil {
+			entityDetector := *s.Detector
+			results, err = entityDetector.Sort(commentSort(order)...).Limit(budget).PullAll(ctx)
+		} else {
+			// read on from the cursor so that every sibling is reachable
+			predicates := commentsVisibleTo(userName, moderator)
+			if cursor.Parent.IsZero() {
+				if s.RootId == nil {
+					return fmt.Errorf("Comment Thread Session missing RootId")
+				}
+				predicates = append(predicates, km.Fld("Root").ID().Eq(*s.RootId))
+			}
+			results, err = pullThreadSiblings(ctx, *cursor, order, budget, predicates)
+		}
+		if err != nil {
+			return fmt.Errorf("Comment Thread PullAll request error: %v", err)
+		}
+
+		results, err = completeThread(ctx, results, order, depth, budget, userName, moderator)
+		if err != nil {
+			return fmt.Errorf("Comment Thread branch request error: %v", err)
+		}
+
+		var placed []Comment
+		nodes, more := BuildCommentThread(results, order, depth, width, cursor, func(comment Comment) {
+			placed = append(placed, comment)
+		})
+
+		for _, result := range placed {
+			err := SanitizeResult(s, &result)
+			if err != nil {
+				return err
+			}
+			s.Response.Append(result)
+		}
+
+		if thread, ok := s.Response.(*ThreadResponse); ok {
+			thread.ThreadData = append(thread.ThreadData, nodes...)
+			thread.More = more
+		}
+		return nil
+	}
+}
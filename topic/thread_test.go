@@ -0,0 +1,156 @@
+package topic
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestBuildCommentThread(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	ids := make(map[string]bson.ObjectID)
+	comment := func(name string, parent string, age int) Comment {
+		ids[name] = bson.NewObjectID()
+		c := Comment{Content: name, Parent: ids[parent], EventAt: now.Add(-time.Duration(age) * time.Minute)}
+		c.ID = ids[name]
+		return c
+	}
+
+	ids["gone"] = bson.NewObjectID()
+	comments := []Comment{
+		comment("a", "", 10),
+		comment("b", "", 5),
+		comment("c", "", 1),
+		comment("a1", "a", 9),
+		comment("a2", "a", 8),
+		comment("a1x", "a1", 7),
+		comment("orphan", "gone", 6),
+	}
+
+	var visited []string
+	nodes, more := BuildCommentThread(comments, CommentOrderNew, 2, 2, nil, func(c Comment) {
+		visited = append(visited, c.Content)
+	})
+
+	if len(nodes) != 2 || nodes[0].ID != ids["c"] || nodes[1].ID != ids["b"] {
+		t.Fatalf("unexpected top level %+v", nodes)
+	}
+	if more == "" {
+		t.Fatalf("expected a cursor for the remaining top level comments")
+	}
+
+	cursor, err := parseThreadCursor(more)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a newer comment does not shift the continuation; the orphan is left out
+	comments = append(comments, comment("d", "", 0))
+	rest, more := BuildCommentThread(comments, CommentOrderNew, 2, 2, cursor, nil)
+	if len(rest) != 1 || rest[0].ID != ids["a"] || more != "" {
+		t.Fatalf("unexpected continuation %+v %q", rest, more)
+	}
+
+	a := rest[0]
+	if a.ReplyCount != 2 || len(a.Replies) != 2 || a.Replies[0].ID != ids["a2"] {
+		t.Fatalf("unexpected replies %+v", a)
+	}
+
+	a1 := a.Replies[1]
+	if a1.ReplyCount != 1 || len(a1.Replies) != 0 || a1.More == "" {
+		t.Fatalf("expected the depth limit to leave a cursor, got %+v", a1)
+	}
+
+	cursor, err = parseThreadCursor(a1.More)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deep, _ := BuildCommentThread(comments, CommentOrderNew, 2, 2, cursor, nil)
+	if len(deep) != 1 || deep[0].ID != ids["a1x"] || deep[0].Depth != 2 {
+		t.Fatalf("unexpected deep branch %+v", deep)
+	}
+
+	if strings.Join(visited, ",") != "c,b" {
+		t.Errorf("unexpected visit order %v", visited)
+	}
+
+	cursor, err = parseThreadCursor(ids["orphan"].Hex())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if branch, _ := BuildCommentThread(comments, CommentOrderNew, 2, 2, cursor, nil); len(branch) != 0 {
+		t.Errorf("expected no branch under a comment outside the thread, got %+v", branch)
+	}
+}
+
+func TestPullCommentThreadPaging(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+	root := bson.NewObjectID()
+
+	// more top level comments than the limit, some tied on BestBy and time
+	var siblings []Comment
+	for i := range 7 {
+		c := Comment{Root: root, EventAt: now.Add(-time.Duration(i/2) * time.Minute)}
+		c.ID = bson.NewObjectID()
+		c.BestBy = now.Add(-time.Duration(i/3) * time.Hour)
+		siblings = append(siblings, c)
+	}
+
+	saved := pullThreadSiblings
+	t.Cleanup(func() { pullThreadSiblings = saved })
+	pullThreadSiblings = func(ctx context.Context, cursor threadCursor, order string, limit int64, predicates []expression.QueryFieldPredicate) ([]Comment, error) {
+		var retval []Comment
+		for _, c := range siblings {
+			if c.Parent == cursor.Parent && cursor.follows(c, order) {
+				retval = append(retval, c)
+			}
+		}
+		SortComments(retval, order)
+		return retval[:min(int64(len(retval)), limit)], nil
+	}
+
+	var paged []bson.ObjectID
+	more := threadCursor{}.String()
+	for pages := 0; more != ""; pages++ {
+		if pages > len(siblings) {
+			t.Fatalf("paging did not end, read %d comments", len(paged))
+		}
+
+		query := url.Values{"sort": {CommentOrderBest}, "width": {"2"}, "more": {more}}
+		s := &Session[Comment]{
+			RequestContext: RequestContext{
+				Request:        httptest.NewRequest("GET", "/comments?"+query.Encode(), nil),
+				Response:       NewThreadResponse(),
+				RootId:         &root,
+				viewerResolved: true,
+			},
+			Detector: &matter.Detector[Comment]{},
+		}
+		if err := PullCommentThread(3)(s); err != nil {
+			t.Fatalf("PullCommentThread failed: %v", err)
+		}
+
+		thread := s.Response.(*ThreadResponse)
+		for _, node := range thread.ThreadData {
+			paged = append(paged, node.ID)
+		}
+		more = thread.More
+	}
+
+	SortComments(siblings, CommentOrderBest)
+	if len(paged) != len(siblings) {
+		t.Fatalf("expected to page through all %d comments, got %d", len(siblings), len(paged))
+	}
+	for i := range siblings {
+		if paged[i] != siblings[i].ID {
+			t.Errorf("expected %s at %d, got %s", siblings[i].ID.Hex(), i, paged[i].Hex())
+		}
+	}
+}
@@ -0,0 +1,11 @@
+package topic
+
+type ThreadResponse struct {
+	EntangledResponse
+	ThreadData []CommentNode `xml:"-" json:"ThreadData,omitempty" bson:"-" `
+	More       string        `xml:"-" json:"More,omitempty" bson:"-" `
+}
+
+func NewThreadResponse() Response {
+	return &ThreadResponse{}
+}
@@ -16,6 +16,7 @@ import (
 	"git.mypierian.com/borghives/kosmos-go/matter"
 	"git.mypierian.com/borghives/websession"
 	"github.com/borghives/sitepages"
+	"github.com/borghives/sitepages/search"
 	"go.mongodb.org/mongo-driver/v2/bson"
 )
 
@@ -27,6 +28,16 @@ type Sanitizable interface {
 	Sanitize(context RequestContext) error
 }
 
+type Visible interface {
+	VisibleTo(userName string, moderator bool) bool
+}
+
+// VisibleQuery is implemented by models whose visibility can also be applied
+// in the query, so that a limit is not spent on results the user cannot see.
+type VisibleQuery interface {
+	VisibleFilter() Filter
+}
+
 // ##### Page  #####
 type Page sitepages.Page
 
@@ -107,6 +118,33 @@ func (p *Page) Decohere(ripple matter.Ripple) error {
 	if err != nil {
 		log.Printf("Page Decohere: failed to add author to root stat %v", err)
 	}
+
+	err = RecordActivity(context.Background(), p.Root, ActivityVersion)
+	if err != nil {
+		log.Printf("Page Decohere: failed to record root activity %v", err)
+	}
+
+	stanzas, err := newStanzaCount(context.Background(), sitepages.Page(*p))
+	if err != nil {
+		log.Printf("Page Decohere: failed to count new stanzas %v", err)
+	}
+
+	err = RecordAuthorContribution(context.Background(), p.Author, AuthorVersion, 1)
+	if err == nil {
+		err = RecordAuthorContribution(context.Background(), p.Author, AuthorStanza, stanzas)
+	}
+	if err != nil {
+		log.Printf("Page Decohere: failed to record author stat %v", err)
+	}
+
+	if SearchIndex != nil {
+		err = search.IndexRoot(context.Background(), SearchIndex, p.Root)
+		if err != nil {
+			log.Printf("Page Decohere: failed to index root %v", err)
+		}
+	}
+
+	invalidateFeed(context.Background(), cacheFeedTag, cacheRootTag(p.Root))
 	return nil
 }
 
@@ -293,6 +331,7 @@ func (c Comment) GetRootID() bson.ObjectID {
 
 func (c Comment) TransitionStates(frame entanglement.Session) entanglement.TypeStateCorrelation {
 	correlation := make(entanglement.TypeStateCorrelation)
+	correlation.Update(EntangleCommentEditProperties(frame, c.ID, c.Root))
 	return correlation
 }
 
@@ -326,6 +365,14 @@ func (c Comment) CheckTransition(frame entanglement.Session) error {
 func (p *Comment) Sanitize(context RequestContext) error {
 	if context.Request.Method == "PUT" {
 		p.UserName = context.GetUserName()
+		// unvoted, ranked by when it was posted until a vote rescores it
+		p.BestBy = commentBestBy(*p)
+
+		state, err := initialCommentState(context.Request.Context(), p.UserName)
+		if err != nil {
+			return fmt.Errorf("Comment Sanitize: %v", err)
+		}
+		p.State = state
 	}
 	return nil
 }
@@ -336,13 +383,21 @@ func (c *Comment) Decohere(ripple matter.Ripple) error {
 		return err
 	}
 
-	stat := PageStat{}
-	stat.ID = c.Root
-	stat.IncrCommentCount()
-	err = kosmos.Record(context.Background(), &stat)
-	if err != nil {
-		log.Printf("Comment Decohere: Failed to add page comment stat %v", err)
+	// held comments are counted once approved
+	if c.CommentState() == CommentVisible {
+		stat := PageStat{}
+		stat.ID = c.Root
+		stat.IncrCommentCount()
+		err = kosmos.Record(context.Background(), &stat)
+		if err != nil {
+			log.Printf("Comment Decohere: Failed to add page comment stat %v", err)
+		}
 	}
+
+	creditComment(context.Background(), *c)
+
+	// the comment count of the root ranks it in TopFreshPageRoot
+	invalidateFeed(context.Background(), cacheFeedTag, cacheRootTag(c.Root))
 	return nil
 }
 
@@ -0,0 +1,223 @@
+package topic
+
+import (
+	"context"
+	"encoding/xml"
+	"log"
+	"sync"
+	"time"
+
+	km "git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/matter"
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+var VIEW_DEDUPE_WINDOW = 30 * time.Minute
+var VIEW_FLUSH_INTERVAL = 10 * time.Second
+
+// VersionStat holds per page version counters; its ID is the page version ID.
+type VersionStat struct {
+	km.BaseModel `bson:",inline" kosmos:"version_stat"`
+	XMLName      xml.Name      `xml:"version_stat" json:"-" bson:"-"`
+	Root         bson.ObjectID `xml:"root" json:"root" bson:"root"`
+	ViewCount    int           `xml:"viewcount" json:"viewcount" bson:"view_count,omitempty"`
+	viewIncr     int
+}
+
+func (v *VersionStat) AddViews(n int) {
+	v.viewIncr += n
+	v.ViewCount += n
+}
+
+func (v *VersionStat) Collapse() matter.Ripple {
+	ripple := v.BaseModel.Collapse()
+	ripple.Set("root", v.Root)
+	ripple.Set("view_count", v.ViewCount)
+	ripple.DoIncr("view_count", v.viewIncr)
+
+	v.ViewCount = 0
+	return ripple
+}
+
+func (v *VersionStat) Decohere(ripple matter.Ripple) error {
+	views, ok := ripple.Get("view_count")
+	if ok {
+		v.ViewCount = views.(int)
+	}
+	v.viewIncr = 0
+
+	return v.BaseModel.Decohere(ripple)
+}
+
+// pageViews adds views to the PageStat of a root and records nothing else.
+// Recording a PageStat would also refresh its UpdatedTime, which
+// TopFreshPageRoot and the scorers read as the last change of the root.
+type pageViews struct {
+	km.BaseModel `bson:",inline" kosmos:"page_stat"`
+	viewIncr     int
+}
+
+func (p *pageViews) Collapse() matter.Ripple {
+	ripple := p.BaseModel.Collapse()
+	ripple.DoIncr("view_count", p.viewIncr)
+	return ripple
+}
+
+type viewKey struct {
+	session bson.ObjectID
+	topic   bson.ObjectID
+}
+
+// ViewTracker counts page reads at most once per websession and topic within
+// its window. Counts are buffered in memory and written by Run so that reads
+// never wait on the database. Deduplication is per process: behind several
+// instances a session is counted once by each instance that serves it.
+type ViewTracker struct {
+	window   time.Duration
+	record   func(ctx context.Context, model any) error
+	mutex    sync.Mutex
+	seen     map[viewKey]time.Time
+	roots    map[bson.ObjectID]int
+	versions map[bson.ObjectID]int
+	rootOf   map[bson.ObjectID]bson.ObjectID
+}
+
+func NewViewTracker(window time.Duration) *ViewTracker {
+	return &ViewTracker{
+		window:   window,
+		record:   km.Record,
+		seen:     make(map[viewKey]time.Time),
+		roots:    make(map[bson.ObjectID]int),
+		versions: make(map[bson.ObjectID]int),
+		rootOf:   make(map[bson.ObjectID]bson.ObjectID),
+	}
+}
+
+// Track counts a view of version (of root) by session. Root and version are
+// deduplicated independently so reading two versions of a root counts once
+// for the root and once for each version.
+func (v *ViewTracker) Track(session bson.ObjectID, root bson.ObjectID, version bson.ObjectID, now time.Time) bool {
+	if session.IsZero() || root.IsZero() {
+		return false
+	}
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	counted := false
+	if v.firstSeen(viewKey{session, root}, now) {
+		v.roots[root] += 1
+		counted = true
+	}
+
+	if !version.IsZero() && v.firstSeen(viewKey{session, version}, now) {
+		v.versions[version] += 1
+		v.rootOf[version] = root
+		counted = true
+	}
+	return counted
+}
+
+func (v *ViewTracker) firstSeen(key viewKey, now time.Time) bool {
+	last, ok := v.seen[key]
+	if ok && now.Sub(last) < v.window {
+		return false
+	}
+	v.seen[key] = now
+	return true
+}
+
+// Flush writes the buffered counts and forgets sessions outside the window.
+// Counts that fail to be written are merged back into the buffer for the next
+// flush.
+func (v *ViewTracker) Flush(ctx context.Context, now time.Time) error {
+	v.mutex.Lock()
+	roots, versions, rootOf := v.roots, v.versions, v.rootOf
+	v.roots = make(map[bson.ObjectID]int)
+	v.versions = make(map[bson.ObjectID]int)
+	v.rootOf = make(map[bson.ObjectID]bson.ObjectID)
+	for key, last := range v.seen {
+		if now.Sub(last) >= v.window {
+			delete(v.seen, key)
+		}
+	}
+	v.mutex.Unlock()
+
+	var retErr error
+	for root, count := range roots {
+		views := pageViews{viewIncr: count}
+		views.ID = root
+		if err := v.record(ctx, &views); err != nil {
+			retErr = err
+			continue
+		}
+		delete(roots, root)
+	}
+
+	for version, count := range versions {
+		stat := VersionStat{Root: rootOf[version]}
+		stat.ID = version
+		stat.AddViews(count)
+		if err := v.record(ctx, &stat); err != nil {
+			retErr = err
+			continue
+		}
+		delete(versions, version)
+	}
+
+	if retErr != nil {
+		v.mutex.Lock()
+		for root, count := range roots {
+			v.roots[root] += count
+		}
+		for version, count := range versions {
+			v.versions[version] += count
+			v.rootOf[version] = rootOf[version]
+		}
+		v.mutex.Unlock()
+	}
+	return retErr
+}
+
+// Run flushes the tracker every VIEW_FLUSH_INTERVAL until ctx is done.
+func (v *ViewTracker) Run(ctx context.Context) {
+	ticker := time.NewTicker(VIEW_FLUSH_INTERVAL)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := v.Flush(context.Background(), time.Now()); err != nil {
+				log.Printf("View tracker final flush failed: %v", err)
+			}
+			return
+		case now := <-ticker.C:
+			if err := v.Flush(ctx, now); err != nil {
+				log.Printf("View tracker flush failed: %v", err)
+			}
+		}
+	}
+}
+
+// TrackPageView counts a view of the target page of the response. It never
+// fails the request: readers without a session are simply not counted.
+func TrackPageView(tracker *ViewTracker) HandlerFunc[Page] {
+	return func(s *Session[Page]) error {
+		if s.Response == nil {
+			return nil
+		}
+
+		page, ok := s.Response.GetTarget().(Page)
+		if !ok {
+			return nil
+		}
+
+		session, err := s.VerifySession()
+		if err != nil || session == nil {
+			return nil
+		}
+
+		tracker.Track(session.ID, page.Root, page.ID, time.Now())
+		return nil
+	}
+}
@@ -0,0 +1,87 @@
+package topic
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/v2/bson"
+)
+
+func TestViewTrackerDedupe(t *testing.T) {
+	tracker := NewViewTracker(30 * time.Minute)
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	session := bson.NewObjectID()
+	other := bson.NewObjectID()
+	root := bson.NewObjectID()
+	v1 := bson.NewObjectID()
+	v2 := bson.NewObjectID()
+
+	if !tracker.Track(session, root, v1, now) {
+		t.Errorf("expected first view to count")
+	}
+	if tracker.Track(session, root, v1, now.Add(10*time.Minute)) {
+		t.Errorf("expected repeated view within window to be ignored")
+	}
+	if !tracker.Track(session, root, v2, now.Add(11*time.Minute)) {
+		t.Errorf("expected view of another version to count")
+	}
+	tracker.Track(other, root, v1, now)
+	tracker.Track(session, root, v1, now.Add(31*time.Minute))
+
+	if tracker.roots[root] != 3 {
+		t.Errorf("expected 3 root views, got %d", tracker.roots[root])
+	}
+	if tracker.versions[v1] != 3 || tracker.versions[v2] != 1 {
+		t.Errorf("unexpected version views %v", tracker.versions)
+	}
+
+	if tracker.Track(bson.NilObjectID, root, v1, now) {
+		t.Errorf("expected views without session to be ignored")
+	}
+}
+
+func TestViewTrackerFlushRetry(t *testing.T) {
+	tracker := NewViewTracker(30 * time.Minute)
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	root := bson.NewObjectID()
+	version := bson.NewObjectID()
+	tracker.Track(bson.NewObjectID(), root, version, now)
+	tracker.Track(bson.NewObjectID(), root, version, now)
+
+	tracker.record = func(ctx context.Context, model any) error {
+		return errors.New("unavailable")
+	}
+	if err := tracker.Flush(context.Background(), now); err == nil {
+		t.Fatalf("expected the failed write to be reported")
+	}
+
+	// a view counted between the failed flush and the next one adds up
+	tracker.Track(bson.NewObjectID(), root, version, now)
+	if tracker.roots[root] != 3 || tracker.versions[version] != 3 || tracker.rootOf[version] != root {
+		t.Fatalf("expected failed counts to be kept for the next flush, got %v %v", tracker.roots, tracker.versions)
+	}
+
+	recorded := make(map[bson.ObjectID]int)
+	tracker.record = func(ctx context.Context, model any) error {
+		switch m := model.(type) {
+		case *pageViews:
+			recorded[m.ID] += m.viewIncr
+		case *VersionStat:
+			recorded[m.ID] += m.viewIncr
+		}
+		return nil
+	}
+	if err := tracker.Flush(context.Background(), now); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+	if recorded[root] != 3 || recorded[version] != 3 {
+		t.Errorf("expected the retried counts to be written once, got %v", recorded)
+	}
+	if len(tracker.roots) != 0 || len(tracker.versions) != 0 {
+		t.Errorf("expected an empty buffer after a successful flush")
+	}
+}
@@ -0,0 +1,176 @@
+package topic
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"git.mypierian.com/borghives/kosmos-go"
+	"git.mypierian.com/borghives/kosmos-go/meta/expression"
+)
+
+var MAX_TIME_WINDOW = 366 * 24 * time.Hour
+
+// ParseTimeBound accepts an absolute time (RFC3339 or 2006-01-02) or a time
+// relative to now such as "-24h", "-7d" or "-2w". "now" is also accepted.
+func ParseTimeBound(value string, now time.Time) (time.Time, error) {
+	if value == "now" {
+		return now.UTC(), nil
+	}
+
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		offset, err := parseRelativeDuration(value)
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(offset).UTC(), nil
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC(), nil
+	}
+
+	t, err := time.Parse(time.DateOnly, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time bound %q", value)
+	}
+	return t, nil
+}
+
+// parseRelativeDuration extends time.ParseDuration with day (d) and week (w) units.
+func parseRelativeDuration(value string) (time.Duration, error) {
+	unit := time.Duration(0)
+	switch {
+	case strings.HasSuffix(value, "d"):
+		unit = 24 * time.Hour
+	case strings.HasSuffix(value, "w"):
+		unit = 7 * 24 * time.Hour
+	}
+
+	if unit == 0 {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid relative time %q", value)
+		}
+		return d, nil
+	}
+
+	n, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid relative time %q", value)
+	}
+
+	// checked before multiplying, which would wrap around
+	if limit := int64(math.MaxInt64 / unit); n > limit || n < -limit {
+		return 0, fmt.Errorf("relative time out of range %q", value)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+// ByTimeWindow restricts the field to [since, until) read from the query
+// parameters sinceName and untilName. Either bound may be omitted: a missing
+// until defaults to now when enforcing MAX_TIME_WINDOW, and a missing since to
+// MAX_TIME_WINDOW before until.
+func (f FieldPredicate) ByTimeWindow(sinceName, untilName string) Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		query := s.URLQuery()
+		sinceStr := query.Get(sinceName)
+		untilStr := query.Get(untilName)
+		if sinceStr == "" && untilStr == "" {
+			return nil, NewMissingInput(fmt.Sprintf("missing query parameter: %s or %s", sinceName, untilName), http.StatusBadRequest)
+		}
+
+		now := time.Now().UTC()
+		var since, until time.Time
+		var err error
+		if sinceStr != "" {
+			since, err = ParseTimeBound(sinceStr, now)
+			if err != nil {
+				return nil, NewStatusError(err, http.StatusBadRequest)
+			}
+		}
+
+		if untilStr != "" {
+			until, err = ParseTimeBound(untilStr, now)
+			if err != nil {
+				return nil, NewStatusError(err, http.StatusBadRequest)
+			}
+		}
+
+		return f.window(since, until, now)
+	}
+}
+
+// ByMonthFromQuery restricts the field to the calendar month (2006-01) given
+// by the query parameter, e.g. for archive pages.
+func (f FieldPredicate) ByMonthFromQuery(queryName string) Filter {
+	return func(s RequestContext) (*expression.QueryFieldPredicate, error) {
+		monthStr := s.URLQuery().Get(queryName)
+		if monthStr == "" {
+			return nil, NewMissingInput(fmt.Sprintf("missing query parameter: %s", queryName), http.StatusBadRequest)
+		}
+
+		since, err := time.Parse("2006-01", monthStr)
+		if err != nil {
+			return nil, NewStatusString("invalid month from query", http.StatusBadRequest)
+		}
+
+		return f.window(since, since.AddDate(0, 1, 0), time.Now().UTC())
+	}
+}
+
+// timeBounds checks the bounds of a window, defaulting a missing since to
+// MAX_TIME_WINDOW before until.
+func timeBounds(since time.Time, until time.Time, now time.Time) (time.Time, time.Time, error) {
+	if since.IsZero() && !until.IsZero() {
+		since = until.Add(-MAX_TIME_WINDOW)
+	}
+
+	if !since.IsZero() {
+		end := until
+		if end.IsZero() {
+			end = now
+		}
+
+		if end.Before(since) {
+			return since, until, NewStatusString("time window ends before it starts", http.StatusBadRequest)
+		}
+
+		if end.Sub(since) > MAX_TIME_WINDOW {
+			return since, until, NewStatusString("time window too large", http.StatusBadRequest)
+		}
+	}
+	return since, until, nil
+}
+
+func (f FieldPredicate) window(since time.Time, until time.Time, now time.Time) (*expression.QueryFieldPredicate, error) {
+	since, until, err := timeBounds(since, until, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var predicates []expression.QueryFieldPredicate
+	if !since.IsZero() {
+		predicates = append(predicates, kosmos.Fld(f.FieldName).Gte(since))
+	}
+	if !until.IsZero() {
+		predicates = append(predicates, kosmos.Fld(f.FieldName).Lt(until))
+	}
+
+	return combinePredicates(predicates, kosmos.And), nil
+}
+
+func ByEventWindow() Filter {
+	return Fld("EventAt").ByTimeWindow("since", "until")
+}
+
+func ByCreatedWindow() Filter {
+	return Fld("CreatedTime").ByTimeWindow("since", "until")
+}
+
+func ByUpdatedWindow() Filter {
+	return Fld("UpdatedTime").ByTimeWindow("since", "until")
+}